@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+	"uk.ac.bris.cs/gameoflife/gol/broker"
+)
+
+func main() {
+	b := broker.New()
+	pAddr := flag.String("port", "8030", "Port to listen on")
+	httpPort := flag.String("http", "", "Port to serve the HTTP/JSON API and the live view on, as well as RPCs on -port. The HTTP API is off if empty")
+	flag.IntVar(&b.SnapshotInterval, "snapshot", b.SnapshotInterval, "How many turns to process between pulling the whole world back from the engines")
+	flag.DurationVar(&b.EngineTimeout, "timeout", b.EngineTimeout, "How long to wait for an engine to process a turn before dropping it")
+	flag.DurationVar(&b.PauseLease, "lease", b.PauseLease, "How long a job stays paused unless the controller which paused it renews the pause")
+	flag.StringVar(&b.CheckpointDir, "checkpoints", "", "Directory to checkpoint jobs to, so they can be recovered if the broker dies. Checkpointing is off if empty")
+	flag.IntVar(&b.CheckpointTurns, "checkpointturns", 10000, "How many turns to process between checkpoints, 0 to only checkpoint on a timer")
+	flag.DurationVar(&b.CheckpointInterval, "checkpointevery", time.Minute, "How long to wait between checkpoints, 0 to only checkpoint every so many turns")
+	recoverJobs := flag.Bool("recover", false, "Resume the unfinished jobs found in the checkpoint directory")
+	flag.Parse()
+
+	if b.CheckpointDir != "" {
+		jobs, err := b.Checkpointed()
+		if err != nil {
+			fmt.Println("Failed to read checkpoints: " + err.Error())
+			os.Exit(1)
+		}
+		if len(jobs) > 0 && *recoverJobs {
+			if _, err = b.Recover(); err != nil {
+				fmt.Println("Failed to recover jobs: " + err.Error())
+				os.Exit(1)
+			}
+		} else if len(jobs) > 0 {
+			fmt.Println("Found " + strconv.Itoa(len(jobs)) + " unfinished jobs, restart with -recover to resume them:")
+			for _, job := range jobs {
+				fmt.Printf("Job %d: %s, %dx%d, checkpointed at turn %d of %d\n", job.ID, job.Image, job.Width, job.Height, job.Turn, job.Turns)
+			}
+		}
+	}
+
+	if err := b.Start(":" + *pAddr); err != nil {
+		fmt.Println("Failed to listen on port " + *pAddr + ": " + err.Error())
+		os.Exit(1)
+	}
+	fmt.Println("Game Of Life Broker V1 listening on port: " + *pAddr)
+	if *httpPort != "" {
+		if err := b.StartHTTP(":" + *httpPort); err != nil {
+			fmt.Println("Failed to serve HTTP on port " + *httpPort + ": " + err.Error())
+			b.Stop()
+			os.Exit(1)
+		}
+		fmt.Println("Serving HTTP API on port: " + *httpPort + ", live view at http://localhost:" + *httpPort + "/")
+	}
+	fmt.Println("Waiting for GOL Engines to register...")
+
+	// Runs until a controller shuts the broker down with KillEngine.
+	<-b.Done()
+}
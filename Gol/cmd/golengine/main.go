@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"uk.ac.bris.cs/gameoflife/gol/golengine"
+	"uk.ac.bris.cs/gameoflife/gol/kernel"
+)
+
+func main() {
+	pAddr := flag.String("port", "8031", "Port to listen on")
+	ip := flag.String("ip", "127.0.0.1", "IP address the broker should use to reach this engine")
+	bAddr := flag.String("broker", "127.0.0.1:8030", "Address of the broker to register with")
+	capacity := flag.Int("capacity", runtime.NumCPU(), "Relative amount of work this engine can take on")
+	kernelName := flag.String("kernel", "naive", "Kernel used to compute each turn, naive or bitwise")
+	flag.Parse()
+
+	nextState, err := kernel.ByName(*kernelName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	e := golengine.New(nextState)
+	if err = e.Start(":" + *pAddr); err != nil {
+		fmt.Println("Failed to listen on port " + *pAddr + ": " + err.Error())
+		os.Exit(1)
+	}
+	fmt.Println("Super Cool Distributed Game of Life Engine is running on port: " + *pAddr)
+
+	fmt.Println("Registering with broker at: " + *bAddr)
+	if err = e.Register(*bAddr, *ip, *capacity); err != nil {
+		fmt.Println("Failed to register with broker: " + err.Error())
+		os.Exit(1)
+	}
+
+	// Remove this engine from the broker when the process is interrupted or terminated.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-signals:
+		fmt.Println("Deregistering from broker...")
+		if err = e.Deregister(); err != nil {
+			fmt.Println("Failed to deregister from broker:", err)
+		}
+		e.Stop()
+	case <-e.Done():
+	}
+}
@@ -1,206 +1,482 @@
-package main
+// Package broker splits a Game of Life job between the GOL Engines which register with it,
+// passing the halo rows between them each turn.
+package broker
 
 import (
-	"flag"
+	"errors"
 	"fmt"
-	"log"
-	"net"
+	"net/http"
 	"net/rpc"
-	"os"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 	"uk.ac.bris.cs/gameoflife/gol/stubs"
-	"uk.ac.bris.cs/gameoflife/util"
 )
 
-var world [][]byte
-var turn = 0
-var turns int
-var m sync.Mutex
-var width int
-var height int
-var working = false
-var aliveCells []util.Cell
-var engines = make(map[int]*rpc.Client)
+// engine is a GOL Engine which has registered itself with the broker.
+type engine struct {
+	client   *rpc.Client
+	address  string
+	capacity int
+}
+
+// strip is a band of rows of the world held by a single engine.
+type strip struct {
+	offset, height int
+}
 
-type GolEngine struct{}
+// assignment pairs a strip with the engine holding it, along with the strip's current first and last rows.
+// These are the halo rows its neighbouring engines need for the next turn.
+type assignment struct {
+	engine      *engine
+	strip       strip
+	top, bottom []uint64
+}
 
-func startEngine(client *rpc.Client, world [][]byte, id, engineHeight int, out chan<- []util.Cell) {
-	args := stubs.EngineArgs{TotalWorld: world, TWidth: width, THeight: height, Height: engineHeight, Offset: engineHeight * id}
-	response := new(stubs.EngineResponse)
+// Broker serves the GolEngine RPCs to local controllers, running each job they submit across the engines.
+type Broker struct {
+	// SnapshotInterval is how many turns are processed between pulling the whole world back from the engines.
+	SnapshotInterval int
+	// EngineTimeout is how long the broker waits for an engine to answer before treating it as dead.
+	EngineTimeout time.Duration
+	// PauseLease is how long a job stays paused unless the controller which paused it renews the pause.
+	PauseLease time.Duration
+	// CheckpointDir is where jobs are checkpointed to, so they can be recovered if the broker dies.
+	// Jobs are only checkpointed if it is set, every CheckpointTurns turns or CheckpointInterval, whichever comes first.
+	// Leaving either of those zero turns that trigger off.
+	CheckpointDir      string
+	CheckpointTurns    int
+	CheckpointInterval time.Duration
+
+	em           sync.Mutex // guards engines and nextEngineID, separate from the jobs' locks so engines can register while a job is paused
+	engines      map[int]*engine
+	nextEngineID int
+	registered   *sync.Cond // broadcast on em when an engine registers or the broker stops
+	stopped      bool
+
+	jm        sync.Mutex // guards jobs and nextJobID, which starts at 1 so a job ID of 0 can mean no job
+	jobs      map[int]*job
+	nextJobID int
+
+	server   *stubs.Server
+	http     *http.Server // serves the HTTP API, if StartHTTP was called
+	httpAddr string
+	done     chan struct{}
+	stopOnce sync.Once
+}
 
-	err := client.Call(stubs.ProcessTurn, args, response)
-	if err != nil {
-		log.Fatal("Error when starting engine with ID: "+strconv.Itoa(id), err)
+// New returns a broker with the default snapshot interval and engine timeout, ready to Start.
+func New() *Broker {
+	b := &Broker{
+		SnapshotInterval: 100,
+		EngineTimeout:    30 * time.Second,
+		PauseLease:       10 * time.Second,
+		engines:          make(map[int]*engine),
+		jobs:             make(map[int]*job),
+		nextJobID:        1,
+		done:             make(chan struct{}),
 	}
-	out <- response.AliveCells
+	b.registered = sync.NewCond(&b.em)
+	return b
 }
 
-func emptyWorld() [][]byte {
+// Start serves the broker on addr, returning once it is listening. Use port 0 for an ephemeral port.
+// New jobs are given IDs after any jobs in CheckpointDir, so their checkpoints are never overwritten.
+func (b *Broker) Start(addr string) (err error) {
+	if b.CheckpointDir != "" {
+		checkpoints, err := readCheckpoints(b.CheckpointDir)
+		if err != nil {
+			return err
+		}
+		b.jm.Lock()
+		for _, c := range checkpoints {
+			if c.Job >= b.nextJobID {
+				b.nextJobID = c.Job + 1
+			}
+		}
+		b.jm.Unlock()
+	}
+	b.server, err = stubs.Serve(addr, b)
+	return
+}
+
+// Addr returns the address the broker is listening on.
+func (b *Broker) Addr() string {
+	return b.server.Addr()
+}
+
+// Stop closes the broker's connections to the engines and to any controllers. It is safe to call more than once.
+// Paused jobs are resumed, so they fail without their engines and finish rather than waiting forever.
+func (b *Broker) Stop() {
+	b.stopOnce.Do(func() {
+		b.server.Close()
+		if b.http != nil {
+			b.http.Close()
+		}
+		b.em.Lock()
+		for id, e := range b.engines {
+			e.client.Close()
+			delete(b.engines, id)
+		}
+		b.stopped = true
+		b.registered.Broadcast()
+		b.em.Unlock()
+		b.jm.Lock()
+		for _, j := range b.jobs {
+			j.m.Lock()
+			j.resume()
+			j.m.Unlock()
+		}
+		b.jm.Unlock()
+		close(b.done)
+	})
+}
+
+// Done is closed once the broker has stopped, whether by Stop or by a controller calling KillEngine.
+func (b *Broker) Done() <-chan struct{} {
+	return b.done
+}
 
-	world = make([][]byte, width)
-	for x := 0; x < width; x++ {
-		world[x] = make([]byte, height)
+// callEngine calls an engine, giving up if it takes longer than EngineTimeout.
+func (b *Broker) callEngine(e *engine, method string, args interface{}, reply interface{}) error {
+	call := e.client.Go(method, args, reply, nil)
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(b.EngineTimeout):
+		return errors.New("timed out after " + b.EngineTimeout.String())
 	}
+}
 
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			world[x][y] = 0
+// partition splits height rows into one strip per engine, sized in proportion to each engine's capacity.
+// Rows that don't divide evenly are spread one at a time across the engines with the largest leftover share,
+// so no rows are left unassigned. Engines whose share rounds down to nothing are given an empty strip.
+func partition(height int, capacities []int) []strip {
+	total := 0
+	weights := make([]int, len(capacities))
+	for i, capacity := range capacities {
+		weights[i] = capacity
+		if weights[i] < 1 {
+			weights[i] = 1
 		}
+		total += weights[i]
+	}
+
+	heights := make([]int, len(weights))
+	leftovers := make([]int, len(weights))
+	remaining := height
+	for i, weight := range weights {
+		heights[i] = height * weight / total
+		leftovers[i] = height * weight % total
+		remaining -= heights[i]
+	}
+
+	// Hand out the remaining rows by largest leftover, ties going to the earlier engine.
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return leftovers[order[a]] > leftovers[order[b]]
+	})
+	for i := 0; i < remaining; i++ {
+		heights[order[i]]++
 	}
 
-	return world
+	strips := make([]strip, len(heights))
+	offset := 0
+	for i, h := range heights {
+		strips[i] = strip{offset: offset, height: h}
+		offset += h
+	}
+	return strips
 }
 
-func calculateAliveCells(width, height int, world [][]byte) []util.Cell {
-	newCell := []util.Cell{}
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			if world[x][y] == 0xff {
-				newCell = append(newCell, util.Cell{y, x})
-			}
+// dropEngine removes an engine which failed to answer, so it is not given any more work.
+func (b *Broker) dropEngine(e *engine, reason error) {
+	b.em.Lock()
+	defer b.em.Unlock()
+	for id, registered := range b.engines {
+		if registered == e {
+			fmt.Println("Dropping Engine with ID: " + strconv.Itoa(id) + " at " + e.address + ": " + reason.Error())
+			e.client.Close()
+			delete(b.engines, id)
+			return
 		}
 	}
-	return newCell
 }
 
-func (g *GolEngine) ProcessTurns(args stubs.GolArgs, res *stubs.GolAliveCells) (err error) {
-	turns = args.Turns
-	turn = 0
-	world = args.World
-	width = args.Width
-	height = args.Height
-	working = true
-	aliveCells = calculateAliveCells(width, height, world) // initialise with current alive for 0 turn tests
+// waitForEngines blocks until at least one engine is registered, or the broker stops.
+func (b *Broker) waitForEngines() {
+	b.em.Lock()
+	defer b.em.Unlock()
+	for len(b.engines) == 0 && !b.stopped {
+		b.registered.Wait()
+	}
+}
 
-	engineCount := len(engines)
-	engineHeight := height / engineCount
+// activeEngines returns the currently registered engines, ordered by the ID they registered with.
+func (b *Broker) activeEngines() []*engine {
+	b.em.Lock()
+	defer b.em.Unlock()
 
-	out := make([]chan []util.Cell, engineCount)
-	for i := range out {
-		fmt.Println("Creating Channel for Engine with ID " + strconv.Itoa(i))
-		out[i] = make(chan []util.Cell)
+	ids := make([]int, 0, len(b.engines))
+	for id := range b.engines {
+		ids = append(ids, id)
 	}
+	sort.Ints(ids)
 
-	fmt.Println("Channel slice has length: " + strconv.Itoa(len(out)))
-	for turn < turns {
-		m.Lock()
+	active := make([]*engine, len(ids))
+	for i, id := range ids {
+		active[i] = b.engines[id]
+	}
+	return active
+}
 
-		for id := range engines {
-			go startEngine(engines[id], world, id, engineHeight, out[id])
-		}
+// job returns the job with the given ID, if it is still running or its result hasn't been collected.
+func (b *Broker) job(id int) (*job, error) {
+	b.jm.Lock()
+	defer b.jm.Unlock()
+	j, ok := b.jobs[id]
+	if !ok {
+		return nil, errors.New("no job with ID " + strconv.Itoa(id))
+	}
+	return j, nil
+}
 
-		nextWorld := emptyWorld()
-		aliveCells = nil
+// SubmitJob starts processing a new job in the background, returning the ID used to control it.
+func (b *Broker) SubmitJob(args stubs.GolArgs, res *int) (err error) {
+	b.jm.Lock()
+	id := b.nextJobID
+	b.nextJobID++
+	j := newJob(b, id, args)
+	b.jobs[id] = j
+	b.jm.Unlock()
+
+	fmt.Println("Job " + strconv.Itoa(id) + ": submitted " + strconv.Itoa(args.Width) + "x" + strconv.Itoa(args.Height) + " world for " + strconv.Itoa(args.Turns) + " turns")
+	go j.run()
+	*res = id
+	return
+}
 
-		for id := range engines {
+// AwaitJob waits for a job to finish and returns its final world. The job is kept until CollectJob is called,
+// so a controller which goes away while waiting leaves it for another to attach to.
+func (b *Broker) AwaitJob(args stubs.JobArgs, res *stubs.GolAliveCells) (err error) {
+	j, err := b.job(args.Job)
+	if err != nil {
+		return
+	}
+	<-j.done
 
-			var engineCells = <-out[id]
-			aliveCells = append(aliveCells, engineCells...)
+	j.m.Lock()
+	defer j.m.Unlock()
+	if j.err != nil {
+		return j.err
+	}
+	res.TurnsComplete = j.turn
+	res.World = j.snapshot
+	fmt.Println("Job " + strconv.Itoa(j.id) + ": returning " + strconv.Itoa(j.aliveCount) + " Alive Cells to local controller")
+	return
+}
 
-			fmt.Println("Processing " + strconv.Itoa(len(engineCells)) + " Alive Cells from Worker ID: " + strconv.Itoa(id))
+// CollectJob forgets a finished job once its result has been received from AwaitJob, deleting its checkpoint.
+// The checkpoint of a job which failed is kept, so it can be recovered. A running job can't be collected.
+func (b *Broker) CollectJob(args stubs.JobArgs, _ *bool) (err error) {
+	j, err := b.job(args.Job)
+	if err != nil {
+		return
+	}
+	select {
+	case <-j.done:
+	default:
+		return errors.New("job " + strconv.Itoa(j.id) + " is still running")
+	}
 
-			for _, cell := range engineCells {
-				nextWorld[cell.Y][cell.X] = 255
-			}
+	b.jm.Lock()
+	delete(b.jobs, j.id)
+	b.jm.Unlock()
 
-		}
+	j.m.Lock()
+	defer j.m.Unlock()
+	if j.err == nil {
+		j.removeCheckpoint()
+	}
+	fmt.Println("Job " + strconv.Itoa(j.id) + ": collected")
+	return
+}
 
-		fmt.Println("Finished processing turn: " + strconv.Itoa(turn) + "\nWith " + strconv.Itoa(engineCount) + "engines" + "\nWho returned " + strconv.Itoa(len(aliveCells)) + " Alive Cells this turn")
-		world = nextWorld
+// ListJobs describes every job which is running or whose result hasn't been collected yet, oldest first.
+func (b *Broker) ListJobs(_ bool, res *[]stubs.JobInfo) (err error) {
+	b.jm.Lock()
+	ids := make([]int, 0, len(b.jobs))
+	for id := range b.jobs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	jobs := make([]*job, len(ids))
+	for i, id := range ids {
+		jobs[i] = b.jobs[id]
+	}
+	b.jm.Unlock()
 
-		turn++
-		m.Unlock()
+	for _, j := range jobs {
+		*res = append(*res, j.info())
 	}
+	return
+}
 
-	fmt.Println("Returning " + strconv.Itoa(len(aliveCells)) + " to local controller")
-	res.TurnsComplete = turns
-	res.AliveCells = aliveCells
-	working = false
+// ProcessTurns submits a job and waits for it to finish, for callers with no need to control it.
+func (b *Broker) ProcessTurns(args stubs.GolArgs, res *stubs.GolAliveCells) (err error) {
+	var id int
+	if err = b.SubmitJob(args, &id); err != nil {
+		return
+	}
+	job := stubs.JobArgs{Job: id}
+	err = b.AwaitJob(job, res)
+	b.CollectJob(job, new(bool))
+	return
+}
 
+func (b *Broker) DoTick(args stubs.JobArgs, res *stubs.TickReport) (err error) {
+	j, err := b.job(args.Job)
+	if err != nil {
+		return
+	}
+	j.m.Lock()
+	res.AliveCount = j.aliveCount
+	res.Turns = j.turn
+	j.m.Unlock()
 	return
 }
 
-func (g *GolEngine) DoTick(_ bool, res *stubs.TickReport) (err error) {
-	fmt.Println("Got do tick request...")
-	m.Lock()
-	res.AliveCount = len(aliveCells)
-	res.Turns = turn
-	m.Unlock()
+// WatchJob waits until the job has got past turn args.Since, or has finished, then returns the change in its world
+// since that turn. A controller asks again once it has drawn each diff, so however many turns pass in the meantime
+// are coalesced into the next diff, and a slow controller is sent fewer, larger diffs rather than falling behind.
+func (b *Broker) WatchJob(args stubs.WatchArgs, res *stubs.WorldDiff) (err error) {
+	j, err := b.job(args.Job)
+	if err != nil {
+		return
+	}
+	*res = j.watch(args.Since)
 	return
 }
 
-func (g *GolEngine) PauseEngine(_ bool, res *stubs.EngineStatus) (err error) {
-	m.Lock()
-	fmt.Println("Pausing Engines on turn: " + strconv.Itoa(turn))
-	res.Turn = turn
-	res.Working = working
+// PauseEngine pauses a job before its next turn, for PauseLease. Pausing a job which is already paused
+// renews the lease, so a controller keeps a job paused by calling PauseEngine again every so often.
+func (b *Broker) PauseEngine(args stubs.JobArgs, res *stubs.EngineStatus) (err error) {
+	j, err := b.job(args.Job)
+	if err != nil {
+		return
+	}
+	j.m.Lock()
+	defer j.m.Unlock()
+	if !j.paused {
+		fmt.Println("Job " + strconv.Itoa(j.id) + ": pausing Engines on turn: " + strconv.Itoa(j.turn))
+	}
+	j.pause(b.PauseLease)
+	res.Turn = j.turn
+	res.Working = j.working
+	res.Paused = j.paused
 	return
 }
 
-func (g *GolEngine) ResumeEngine(_ bool, res *stubs.EngineStatus) (err error) {
-	fmt.Println("Resuming Engines from turn: " + strconv.Itoa(turn))
-	res.Turn = turn
-	res.Working = working
-	m.Unlock()
+// ResumeEngine resumes a paused job. Resuming a job which isn't paused does nothing.
+func (b *Broker) ResumeEngine(args stubs.JobArgs, res *stubs.EngineStatus) (err error) {
+	j, err := b.job(args.Job)
+	if err != nil {
+		return
+	}
+	j.m.Lock()
+	defer j.m.Unlock()
+	if j.paused {
+		fmt.Println("Job " + strconv.Itoa(j.id) + ": resuming Engines from turn: " + strconv.Itoa(j.turn))
+	}
+	j.resume()
+	res.Turn = j.turn
+	res.Working = j.working
+	res.Paused = j.paused
 	return
 }
 
-func (g *GolEngine) InterruptEngine(_ bool, res *stubs.GolAliveCells) (err error) {
-	m.Lock()
-	fmt.Println("Interrupt triggered, returning current work to controller.")
+func (b *Broker) InterruptEngine(args stubs.JobArgs, res *stubs.GolAliveCells) (err error) {
+	j, err := b.job(args.Job)
+	if err != nil {
+		return
+	}
+	j.m.Lock()
+	defer j.m.Unlock()
+	fmt.Println("Job " + strconv.Itoa(j.id) + ": interrupt triggered, returning current work to controller.")
 
-	res.TurnsComplete = turn
-	res.AliveCells = aliveCells
-	m.Unlock()
+	if j.working {
+		err = j.syncWorld()
+	}
+	res.TurnsComplete = j.snapshotTurn
+	res.World = j.snapshot
 	return
 }
 
-func (g *GolEngine) CheckStatus(_ bool, res *stubs.EngineStatus) (err error) {
-	m.Lock()
-	res.Turn = turn
-	res.Working = working
-	m.Unlock()
+func (b *Broker) CheckStatus(args stubs.JobArgs, res *stubs.EngineStatus) (err error) {
+	j, err := b.job(args.Job)
+	if err != nil {
+		return
+	}
+	j.m.Lock()
+	res.Turn = j.turn
+	res.Working = j.working
+	res.Paused = j.paused
+	res.Recovered = j.recovered
+	res.RecoveredTurn = j.recoveredTurn
+	j.m.Unlock()
 	return
 }
 
-func (g *GolEngine) KillEngine(_ bool, _ *bool) (err error) {
+func (b *Broker) KillEngine(_ bool, _ *bool) (err error) {
 	fmt.Println("Starting shutdown process...")
-	for id := range engines {
+	b.em.Lock()
+	for id, e := range b.engines {
 		fmt.Println("Shutting down Engine with ID: " + strconv.Itoa(id))
-		engines[id].Call(stubs.KillEngine, true, true)
+		b.callEngine(e, stubs.KillEngine, true, new(bool))
 	}
+	b.em.Unlock()
 	fmt.Println("Shutting down Broker...")
-	os.Exit(0)
+	go b.Stop()
 	return
 }
 
-func connectEngines() {
-	var ips = []string{"127.0.0.1:8031", "127.0.0.1:8032"}
-	for id, ip := range ips {
-		fmt.Println("Connecting to Engine with IP: " + ip)
-		engine, e := rpc.Dial("tcp", ip)
-		if e != nil {
-			log.Fatal("connecting to engine error:", e)
-		} else {
-			engines[id] = engine
-			fmt.Println("Connected...")
-		}
+// RegisterEngine is called by a GOL Engine when it starts up. The broker dials back to the address it
+// gives and includes it in the next turn it processes. The ID the engine was given is returned.
+func (b *Broker) RegisterEngine(args stubs.EngineRegistration, res *int) (err error) {
+	fmt.Println("Connecting to Engine with IP: " + args.Address)
+	client, err := rpc.Dial("tcp", args.Address)
+	if err != nil {
+		fmt.Println("connecting to engine error:", err)
+		return
 	}
-}
-
-func main() {
-	pAddr := flag.String("port", "8030", "Port to listen on")
-	flag.Parse()
-	fmt.Println("Game Of Life Broker V1 listening on port: " + *pAddr)
 
-	connectEngines()
-	fmt.Println("Connected to " + strconv.Itoa(len(engines)) + " GOL Engines.")
+	b.em.Lock()
+	id := b.nextEngineID
+	b.nextEngineID++
+	b.engines[id] = &engine{client: client, address: args.Address, capacity: args.Capacity}
+	b.registered.Broadcast()
+	fmt.Println("Registered Engine with ID: " + strconv.Itoa(id) + " and capacity: " + strconv.Itoa(args.Capacity) + ", now have " + strconv.Itoa(len(b.engines)) + " GOL Engines.")
+	b.em.Unlock()
 
-	rpc.Register(&GolEngine{})
-
-	listener, _ := net.Listen("tcp", ":"+*pAddr)
+	*res = id
+	return
+}
 
-	defer listener.Close()
-	rpc.Accept(listener)
+// DeregisterEngine is called by a GOL Engine when it shuts down, so it is no longer given any work.
+func (b *Broker) DeregisterEngine(args stubs.EngineRegistration, _ *bool) (err error) {
+	b.em.Lock()
+	defer b.em.Unlock()
+	for id, e := range b.engines {
+		if e.address == args.Address {
+			e.client.Close()
+			delete(b.engines, id)
+			fmt.Println("Deregistered Engine with ID: " + strconv.Itoa(id) + ", now have " + strconv.Itoa(len(b.engines)) + " GOL Engines.")
+			return
+		}
+	}
+	return errors.New("no engine registered with address " + args.Address)
 }
@@ -0,0 +1,231 @@
+package broker
+
+import (
+	"net/rpc"
+	"strconv"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol/golengine"
+	"uk.ac.bris.cs/gameoflife/gol/kernel"
+	"uk.ac.bris.cs/gameoflife/gol/stubs"
+	"uk.ac.bris.cs/gameoflife/gol/testworld"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// startTestBroker serves a broker on an ephemeral loopback port, stopping it when the test finishes.
+func startTestBroker(t *testing.T) *Broker {
+	b := New()
+	util.Check(b.Start("127.0.0.1:0"))
+	t.Cleanup(b.Stop)
+	return b
+}
+
+// startTestEngines starts n engines in this process, registered with b. They are stopped when the test finishes.
+func startTestEngines(t *testing.T, b *Broker, n int) []*golengine.Engine {
+	var started []*golengine.Engine
+	for i := 0; i < n; i++ {
+		e := golengine.New(kernel.Naive)
+		util.Check(e.Start("127.0.0.1:0"))
+		t.Cleanup(e.Stop)
+		util.Check(e.Register(b.Addr(), "127.0.0.1", 1))
+		started = append(started, e)
+	}
+	return started
+}
+
+// TestEngineFailure kills an engine partway through a 512x512 run and checks the broker still finishes correctly.
+func TestEngineFailure(t *testing.T) {
+	b := startTestBroker(t)
+	started := startTestEngines(t, b, 4)
+
+	client, err := rpc.Dial("tcp", b.Addr())
+	util.Check(err)
+	defer client.Close()
+
+	args := stubs.GolArgs{World: testworld.Read(t, "../../images/512x512.pgm"), Width: 512, Height: 512, Turns: 100}
+	var id int
+	util.Check(client.Call(stubs.SubmitJob, args, &id))
+	job := stubs.JobArgs{Job: id}
+	response := new(stubs.GolAliveCells)
+	call := client.Go(stubs.AwaitJob, job, response, nil)
+
+	status := new(stubs.EngineStatus)
+	for status.Turn < 10 {
+		time.Sleep(10 * time.Millisecond)
+		util.Check(client.Call(stubs.CheckStatus, job, status))
+	}
+	started[1].Stop()
+
+	select {
+	case <-call.Done:
+	case <-time.After(2 * time.Minute):
+		t.Fatal("broker did not finish after an engine was killed")
+	}
+	if call.Error != nil {
+		t.Fatal(call.Error)
+	}
+	if len(b.activeEngines()) != 3 {
+		t.Errorf("expected the killed engine to be dropped, %d engines still registered", len(b.activeEngines()))
+	}
+
+	expected := testworld.Read(t, "../../check/images/512x512x100.pgm").AliveCells()
+	testworld.AssertEqualCells(t, response.World.AliveCells(), expected)
+}
+
+// TestHashLifeJob runs a 512x512 job with HashLife on the broker, which needs no engines at all.
+func TestHashLifeJob(t *testing.T) {
+	client, err := rpc.Dial("tcp", startTestBroker(t).Addr())
+	util.Check(err)
+	defer client.Close()
+
+	args := stubs.GolArgs{World: testworld.Read(t, "../../images/512x512.pgm"), Width: 512, Height: 512, Turns: 100, HashLife: true}
+	response := new(stubs.GolAliveCells)
+	util.Check(client.Call(stubs.ProcessTurns, args, response))
+
+	if response.TurnsComplete != 100 {
+		t.Errorf("expected 100 turns complete, got %d", response.TurnsComplete)
+	}
+	expected := testworld.Read(t, "../../check/images/512x512x100.pgm").AliveCells()
+	testworld.AssertEqualCells(t, response.World.AliveCells(), expected)
+
+	// The job is forgotten once its result has been collected.
+	var jobs []stubs.JobInfo
+	util.Check(client.Call(stubs.ListJobs, true, &jobs))
+	if len(jobs) != 0 {
+		t.Errorf("expected the finished job to be gone once collected, got %+v", jobs)
+	}
+}
+
+// TestConcurrentJobs runs two jobs on the same engines at once, checking neither disturbs the other.
+func TestConcurrentJobs(t *testing.T) {
+	b := startTestBroker(t)
+	startTestEngines(t, b, 3)
+
+	client, err := rpc.Dial("tcp", b.Addr())
+	util.Check(err)
+	defer client.Close()
+
+	var calls []*rpc.Call
+	var responses []*stubs.GolAliveCells
+	for _, size := range []int{512, 64} {
+		name := strconv.Itoa(size) + "x" + strconv.Itoa(size)
+		args := stubs.GolArgs{World: testworld.Read(t, "../../images/"+name+".pgm"), Width: size, Height: size, Turns: 100, Threads: 2}
+		var id int
+		util.Check(client.Call(stubs.SubmitJob, args, &id))
+		response := new(stubs.GolAliveCells)
+		calls = append(calls, client.Go(stubs.AwaitJob, stubs.JobArgs{Job: id}, response, nil))
+		responses = append(responses, response)
+	}
+
+	for i, size := range []int{512, 64} {
+		<-calls[i].Done
+		util.Check(calls[i].Error)
+		name := strconv.Itoa(size) + "x" + strconv.Itoa(size)
+		expected := testworld.Read(t, "../../check/images/"+name+"x100.pgm").AliveCells()
+		testworld.AssertEqualCells(t, responses[i].World.AliveCells(), expected)
+	}
+}
+
+// TestPauseLease checks pausing and resuming can be repeated safely, that a paused job can still be asked about,
+// and that a pause resumes by itself unless it is renewed before its lease runs out.
+func TestPauseLease(t *testing.T) {
+	b := startTestBroker(t)
+	b.PauseLease = 300 * time.Millisecond
+	startTestEngines(t, b, 2)
+
+	client, err := rpc.Dial("tcp", b.Addr())
+	util.Check(err)
+	defer client.Close()
+
+	args := stubs.GolArgs{World: testworld.Read(t, "../../images/64x64.pgm"), Width: 64, Height: 64, Turns: 100000000}
+	var id int
+	util.Check(client.Call(stubs.SubmitJob, args, &id))
+	job := stubs.JobArgs{Job: id}
+
+	status := func() stubs.EngineStatus {
+		status := new(stubs.EngineStatus)
+		util.Check(client.Call(stubs.CheckStatus, job, status))
+		return *status
+	}
+	for status().Turn == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	paused := new(stubs.EngineStatus)
+	util.Check(client.Call(stubs.PauseEngine, job, paused))
+	util.Check(client.Call(stubs.PauseEngine, job, paused))
+	if !paused.Paused {
+		t.Fatal("expected the job to be paused")
+	}
+
+	// Keep renewing the pause for longer than the lease.
+	for i := 0; i < 6; i++ {
+		time.Sleep(100 * time.Millisecond)
+		util.Check(client.Call(stubs.PauseEngine, job, new(stubs.EngineStatus)))
+		util.Check(client.Call(stubs.DoTick, job, new(stubs.TickReport)))
+		if s := status(); !s.Paused || s.Turn != paused.Turn {
+			t.Fatalf("expected the job to stay paused at turn %d while renewed, got %+v", paused.Turn, s)
+		}
+	}
+
+	util.Check(client.Call(stubs.ResumeEngine, job, new(stubs.EngineStatus)))
+	util.Check(client.Call(stubs.ResumeEngine, job, new(stubs.EngineStatus)))
+	time.Sleep(50 * time.Millisecond)
+	if s := status(); s.Paused || s.Turn == paused.Turn {
+		t.Fatalf("expected the job to carry on once resumed, got %+v", s)
+	}
+
+	// A pause which isn't renewed runs out by itself.
+	util.Check(client.Call(stubs.PauseEngine, job, paused))
+	time.Sleep(500 * time.Millisecond)
+	if s := status(); s.Paused || s.Turn == paused.Turn {
+		t.Fatalf("expected the pause to run out after its lease, got %+v", s)
+	}
+}
+
+// TestWatchJob follows a job through its diffs, checking they add up to the final world and come between
+// snapshots when the watcher keeps up, and that a watcher starting with no world is sent the whole of it.
+func TestWatchJob(t *testing.T) {
+	b := startTestBroker(t)
+	b.SnapshotInterval = 10
+	startTestEngines(t, b, 2)
+	client, err := rpc.Dial("tcp", b.Addr())
+	util.Check(err)
+	defer client.Close()
+
+	world := testworld.Read(t, "../../images/512x512.pgm")
+	args := stubs.GolArgs{World: world, Width: 512, Height: 512, Turns: 100, Threads: 2}
+	var id int
+	util.Check(client.Call(stubs.SubmitJob, args, &id))
+
+	between := 0
+	for since := 0; ; {
+		diff := new(stubs.WorldDiff)
+		util.Check(client.Call(stubs.WatchJob, stubs.WatchArgs{Job: id, Since: since}, diff))
+		if diff.Reset || diff.From != since || (diff.Working && diff.Turn <= since) {
+			t.Fatalf("asked for the diff since turn %d, got one from turn %d to %d, reset: %v", since, diff.From, diff.Turn, diff.Reset)
+		}
+		if diff.Turn%b.SnapshotInterval != 0 {
+			between++
+		}
+		world = diff.Apply(world)
+		since = diff.Turn
+		if !diff.Working {
+			break
+		}
+	}
+	if between == 0 {
+		t.Error("expected diffs to turns between snapshots for a watcher keeping up")
+	}
+	expected := testworld.Read(t, "../../check/images/512x512x100.pgm").AliveCells()
+	testworld.AssertEqualCells(t, world.AliveCells(), expected)
+
+	diff := new(stubs.WorldDiff)
+	util.Check(client.Call(stubs.WatchJob, stubs.WatchArgs{Job: id, Since: -1}, diff))
+	if !diff.Reset || diff.Turn != 100 {
+		t.Errorf("expected a reset to turn 100 for a watcher with no world, got a diff to turn %d, reset: %v", diff.Turn, diff.Reset)
+	}
+	testworld.AssertEqualCells(t, diff.Apply(util.BitGrid{}).AliveCells(), expected)
+	util.Check(client.Call(stubs.AwaitJob, stubs.JobArgs{Job: id}, new(stubs.GolAliveCells)))
+}
@@ -0,0 +1,198 @@
+package broker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"uk.ac.bris.cs/gameoflife/gol/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// checkpointMagic starts every checkpoint file, followed by the format version as a big-endian uint32
+// and then the checkpoint itself, gob encoded.
+const checkpointMagic = "GOLCKPT\n"
+
+// checkpointVersion is bumped whenever the checkpoint struct changes in a way older brokers can't read.
+const checkpointVersion = 1
+
+// checkpoint is everything needed to carry on with a job after the broker restarts.
+type checkpoint struct {
+	Job      int
+	Image    string
+	Width    int
+	Height   int
+	Turn     int
+	Turns    int
+	Threads  int
+	Rule     util.Rule
+	HashLife bool
+	Started  time.Time
+	World    util.BitGrid
+}
+
+// checkpointPath is where the checkpoint for a job is kept within dir.
+func checkpointPath(dir string, job int) string {
+	return filepath.Join(dir, "job-"+strconv.Itoa(job)+".checkpoint")
+}
+
+// writeCheckpoint saves c to dir. It is written to a temporary file first and renamed into place,
+// so a crash part way through never leaves a broken checkpoint behind.
+func writeCheckpoint(dir string, c checkpoint) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, "checkpoint")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	w := bufio.NewWriter(file)
+	w.WriteString(checkpointMagic)
+	binary.Write(w, binary.BigEndian, uint32(checkpointVersion))
+	if err = gob.NewEncoder(w).Encode(c); err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), checkpointPath(dir, c.Job))
+}
+
+// readCheckpoint loads a checkpoint written by writeCheckpoint, rejecting files in any other format or version.
+func readCheckpoint(path string) (c checkpoint, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	magic := make([]byte, len(checkpointMagic))
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != checkpointMagic {
+		return c, errors.New(path + " is not a checkpoint")
+	}
+	var version uint32
+	if err = binary.Read(r, binary.BigEndian, &version); err != nil {
+		return
+	}
+	if version != checkpointVersion {
+		return c, fmt.Errorf("%v is checkpoint version %v, this broker reads version %v", path, version, checkpointVersion)
+	}
+	err = gob.NewDecoder(r).Decode(&c)
+	return
+}
+
+// readCheckpoints loads every checkpoint in dir, skipping any which can't be read.
+func readCheckpoints(dir string) ([]checkpoint, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "job-*.checkpoint"))
+	if err != nil {
+		return nil, err
+	}
+	var checkpoints []checkpoint
+	for _, path := range paths {
+		c, err := readCheckpoint(path)
+		if err != nil {
+			fmt.Println("Skipping checkpoint: " + err.Error())
+			continue
+		}
+		checkpoints = append(checkpoints, c)
+	}
+	return checkpoints, nil
+}
+
+// checkpointDue reports whether enough turns or time have passed since the job's last checkpoint to take another.
+// Called with j.m held.
+func (j *job) checkpointDue() bool {
+	b := j.broker
+	if b.CheckpointDir == "" {
+		return false
+	}
+	return (b.CheckpointTurns > 0 && j.turn-j.checkpointTurn >= b.CheckpointTurns) ||
+		(b.CheckpointInterval > 0 && time.Since(j.checkpointTime) >= b.CheckpointInterval)
+}
+
+// checkpoint writes the job's snapshot to disk. Failing to write it is reported but doesn't stop the job.
+// Called with j.m held, once the snapshot is up to date.
+func (j *job) checkpoint() {
+	c := checkpoint{
+		Job:      j.id,
+		Image:    j.image,
+		Width:    j.width,
+		Height:   j.height,
+		Turn:     j.snapshotTurn,
+		Turns:    j.turns,
+		Threads:  j.threads,
+		Rule:     j.rule,
+		HashLife: j.hashLife,
+		Started:  j.started,
+		World:    j.snapshot,
+	}
+	if err := writeCheckpoint(j.broker.CheckpointDir, c); err != nil {
+		fmt.Println("Job " + strconv.Itoa(j.id) + ": failed to write checkpoint: " + err.Error())
+	}
+	j.checkpointTurn = j.snapshotTurn
+	j.checkpointTime = time.Now()
+}
+
+// Recover resumes every job with a checkpoint in CheckpointDir from its latest checkpoint, under the same job ID.
+// Jobs on the engines wait for an engine to register before carrying on. It returns the IDs of the jobs resumed.
+func (b *Broker) Recover() ([]int, error) {
+	checkpoints, err := readCheckpoints(b.CheckpointDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	b.jm.Lock()
+	for _, c := range checkpoints {
+		if _, ok := b.jobs[c.Job]; ok {
+			continue
+		}
+		j := recoverJob(b, c)
+		b.jobs[c.Job] = j
+		if c.Job >= b.nextJobID {
+			b.nextJobID = c.Job + 1
+		}
+		ids = append(ids, c.Job)
+		fmt.Println("Job " + strconv.Itoa(c.Job) + ": recovered " + c.Image + " at turn " + strconv.Itoa(c.Turn) + " of " + strconv.Itoa(c.Turns))
+		go j.run()
+	}
+	b.jm.Unlock()
+	return ids, nil
+}
+
+// Checkpointed describes the jobs with a checkpoint in CheckpointDir, which Recover would resume.
+func (b *Broker) Checkpointed() ([]stubs.JobInfo, error) {
+	checkpoints, err := readCheckpoints(b.CheckpointDir)
+	var jobs []stubs.JobInfo
+	for _, c := range checkpoints {
+		jobs = append(jobs, stubs.JobInfo{ID: c.Job, Image: c.Image, Width: c.Width, Height: c.Height, Turns: c.Turns, Turn: c.Turn, Started: c.Started})
+	}
+	return jobs, err
+}
+
+// removeCheckpoint deletes the job's checkpoint once CollectJob is called. Until then the job keeps its final
+// checkpoint, from which Recover returns the result without running any turns.
+func (j *job) removeCheckpoint() {
+	if j.broker.CheckpointDir == "" {
+		return
+	}
+	if err := os.Remove(checkpointPath(j.broker.CheckpointDir, j.id)); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Job " + strconv.Itoa(j.id) + ": failed to remove checkpoint: " + err.Error())
+	}
+}
@@ -0,0 +1,174 @@
+package broker
+
+import (
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol/stubs"
+	"uk.ac.bris.cs/gameoflife/gol/testworld"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestCheckpointFormat writes a checkpoint and reads it back, and checks files in other formats are rejected.
+func TestCheckpointFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	util.Check(err)
+	defer os.RemoveAll(dir)
+
+	world := testworld.Read(t, "../../images/64x64.pgm")
+	c := checkpoint{Job: 3, Image: "64x64", Width: 64, Height: 64, Turn: 50, Turns: 100, Threads: 4, Rule: util.Conway, Started: time.Now().Round(0), World: world}
+	util.Check(writeCheckpoint(dir, c))
+
+	read, err := readCheckpoint(checkpointPath(dir, 3))
+	util.Check(err)
+	if read.Job != c.Job || read.Turn != c.Turn || read.Turns != c.Turns || read.Rule != c.Rule || !read.Started.Equal(c.Started) {
+		t.Errorf("read back %+v, expected %+v", read, c)
+	}
+	testworld.AssertEqualCells(t, read.World.AliveCells(), world.AliveCells())
+
+	data, err := ioutil.ReadFile(checkpointPath(dir, 3))
+	util.Check(err)
+	data[len(checkpointMagic)+3]++
+	util.Check(ioutil.WriteFile(checkpointPath(dir, 4), data, 0644))
+	if _, err = readCheckpoint(checkpointPath(dir, 4)); err == nil {
+		t.Error("expected a checkpoint from another version to be rejected")
+	}
+	util.Check(ioutil.WriteFile(checkpointPath(dir, 5), []byte("P5\n64 64\n255\n"), 0644))
+	if _, err = readCheckpoint(checkpointPath(dir, 5)); err == nil {
+		t.Error("expected a file which isn't a checkpoint to be rejected")
+	}
+
+	checkpoints, err := readCheckpoints(dir)
+	util.Check(err)
+	if len(checkpoints) != 1 || checkpoints[0].Job != 3 {
+		t.Errorf("expected only the good checkpoint to be read, got %d", len(checkpoints))
+	}
+}
+
+// TestRecover stops a broker part way through a job, then checks a new broker picks the job up from its
+// latest checkpoint and finishes it correctly.
+func TestRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	util.Check(err)
+	defer os.RemoveAll(dir)
+
+	first := startTestBroker(t)
+	first.CheckpointDir = dir
+	first.CheckpointTurns = 10
+	startTestEngines(t, first, 2)
+
+	client, err := rpc.Dial("tcp", first.Addr())
+	util.Check(err)
+	args := stubs.GolArgs{Image: "64x64", World: testworld.Read(t, "../../images/64x64.pgm"), Width: 64, Height: 64, Turns: 100, Threads: 2}
+	var id int
+	util.Check(client.Call(stubs.SubmitJob, args, &id))
+	job := stubs.JobArgs{Job: id}
+
+	status := new(stubs.EngineStatus)
+	for status.Turn < 25 {
+		time.Sleep(time.Millisecond)
+		util.Check(client.Call(stubs.PauseEngine, job, status))
+		if status.Turn < 25 {
+			util.Check(client.Call(stubs.ResumeEngine, job, status))
+		}
+	}
+	client.Close()
+	first.Stop()
+
+	second := New()
+	second.CheckpointDir = dir
+	checkpointed, err := second.Checkpointed()
+	util.Check(err)
+	if len(checkpointed) != 1 || checkpointed[0].ID != id || checkpointed[0].Turn != status.Turn-status.Turn%10 {
+		t.Fatalf("expected job %d checkpointed at turn %d, got %+v", id, status.Turn-status.Turn%10, checkpointed)
+	}
+
+	util.Check(second.Start("127.0.0.1:0"))
+	t.Cleanup(second.Stop)
+	recovered, err := second.Recover()
+	util.Check(err)
+	if len(recovered) != 1 || recovered[0] != id {
+		t.Fatalf("expected job %d to be recovered, got %v", id, recovered)
+	}
+	// The job waits for engines to register before carrying on.
+	startTestEngines(t, second, 2)
+
+	client, err = rpc.Dial("tcp", second.Addr())
+	util.Check(err)
+	defer client.Close()
+	util.Check(client.Call(stubs.CheckStatus, job, status))
+	if !status.Recovered || status.RecoveredTurn != checkpointed[0].Turn {
+		t.Errorf("expected the job to report it was recovered at turn %d, got %+v", checkpointed[0].Turn, status)
+	}
+
+	var next int
+	util.Check(client.Call(stubs.SubmitJob, stubs.GolArgs{World: util.NewBitGrid(16, 16), Width: 16, Height: 16}, &next))
+	if next <= id {
+		t.Errorf("new job given ID %d, which doesn't follow the recovered job %d", next, id)
+	}
+
+	response := new(stubs.GolAliveCells)
+	util.Check(client.Call(stubs.AwaitJob, job, response))
+	expected := testworld.Read(t, "../../check/images/64x64x100.pgm").AliveCells()
+	testworld.AssertEqualCells(t, response.World.AliveCells(), expected)
+
+	if _, err = os.Stat(checkpointPath(dir, id)); err != nil {
+		t.Errorf("expected the checkpoint to be kept until the job was collected: %v", err)
+	}
+	util.Check(client.Call(stubs.CollectJob, job, new(bool)))
+	if _, err = os.Stat(checkpointPath(dir, id)); !os.IsNotExist(err) {
+		t.Error("expected the checkpoint to be removed once the job was collected")
+	}
+}
+
+// TestRecoverFinished checks a job which finishes but is never collected leaves a checkpoint of its final turn,
+// which a new broker returns as the result without replaying any turns.
+func TestRecoverFinished(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	util.Check(err)
+	defer os.RemoveAll(dir)
+
+	first := startTestBroker(t)
+	first.CheckpointDir = dir
+	first.CheckpointTurns = 1000
+	startTestEngines(t, first, 2)
+
+	client, err := rpc.Dial("tcp", first.Addr())
+	util.Check(err)
+	args := stubs.GolArgs{Image: "64x64", World: testworld.Read(t, "../../images/64x64.pgm"), Width: 64, Height: 64, Turns: 100, Threads: 2}
+	var id int
+	util.Check(client.Call(stubs.SubmitJob, args, &id))
+	for working := true; working; {
+		time.Sleep(time.Millisecond)
+		var jobs []stubs.JobInfo
+		util.Check(client.Call(stubs.ListJobs, true, &jobs))
+		working = len(jobs) == 1 && jobs[0].Working
+	}
+	client.Close()
+	first.Stop()
+
+	second := startTestBroker(t)
+	second.CheckpointDir = dir
+	checkpointed, err := second.Checkpointed()
+	util.Check(err)
+	if len(checkpointed) != 1 || checkpointed[0].Turn != 100 {
+		t.Fatalf("expected job %d checkpointed at turn 100, got %+v", id, checkpointed)
+	}
+	_, err = second.Recover()
+	util.Check(err)
+
+	// No engines are started, as there are no turns left to run.
+	client, err = rpc.Dial("tcp", second.Addr())
+	util.Check(err)
+	defer client.Close()
+	response := new(stubs.GolAliveCells)
+	util.Check(client.Call(stubs.AwaitJob, stubs.JobArgs{Job: id}, response))
+	if response.TurnsComplete != 100 {
+		t.Errorf("expected the recovered job to have completed 100 turns, got %d", response.TurnsComplete)
+	}
+	expected := testworld.Read(t, "../../check/images/64x64x100.pgm").AliveCells()
+	testworld.AssertEqualCells(t, response.World.AliveCells(), expected)
+}
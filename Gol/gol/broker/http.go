@@ -0,0 +1,250 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/gol/netpbm"
+	"uk.ac.bris.cs/gameoflife/gol/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// The HTTP API mirrors the RPCs for clients which can't speak net/rpc, sending and receiving JSON:
+//
+//	GET  /                     the live view, a web page drawing a job as it runs
+//	GET  /jobs                 ListJobs
+//	POST /jobs                 SubmitJob, with a PGM image as the body. The turns, threads, rule and hashlife
+//	                           query parameters set up the job, and image names it
+//	GET  /jobs/{id}            CheckStatus
+//	GET  /jobs/{id}/tick       DoTick
+//	POST /jobs/{id}/pause      PauseEngine
+//	POST /jobs/{id}/resume     ResumeEngine
+//	GET  /jobs/{id}/snapshot   InterruptEngine
+//	GET  /jobs/{id}/result     AwaitJob, waiting for the job to finish
+//	POST /jobs/{id}/collect    CollectJob, once the result has been received
+//	GET  /jobs/{id}/live       a WebSocket streaming the job's board as it runs, for the live view
+//	POST /shutdown             KillEngine
+//
+// snapshot and result send the world as a list of alive cells, each {"x": ..., "y": ...}, or as a PGM image
+// with ?format=pgm.
+// Failures are sent as {"error": "..."} with a matching status code.
+
+// worldResponse is a world sent over the HTTP API.
+type worldResponse struct {
+	Turn       int            `json:"turn"`
+	Width      int            `json:"width"`
+	Height     int            `json:"height"`
+	AliveCount int            `json:"alive_count"`
+	Alive      []cellResponse `json:"alive"`
+}
+
+// cellResponse is an alive cell in a worldResponse.
+type cellResponse struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// cellResponses converts cells for a worldResponse.
+func cellResponses(cells []util.Cell) []cellResponse {
+	res := make([]cellResponse, len(cells))
+	for i, cell := range cells {
+		res[i] = cellResponse{X: cell.X, Y: cell.Y}
+	}
+	return res
+}
+
+// StartHTTP serves the HTTP API on addr alongside the RPCs, returning once it is listening.
+// Use port 0 for an ephemeral port. Stop closes it along with everything else.
+func (b *Broker) StartHTTP(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	b.http = &http.Server{Handler: b}
+	b.httpAddr = listener.Addr().String()
+	go b.http.Serve(listener)
+	return nil
+}
+
+// HTTPAddr returns the address the HTTP API is listening on.
+func (b *Broker) HTTPAddr() string {
+	return b.httpAddr
+}
+
+// ServeHTTP routes a request to the HTTP API.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "" && r.Method == http.MethodGet:
+		pageHTTP(w)
+	case len(parts) == 1 && parts[0] == "jobs" && r.Method == http.MethodGet:
+		var jobs []stubs.JobInfo
+		b.ListJobs(true, &jobs)
+		if jobs == nil {
+			jobs = []stubs.JobInfo{}
+		}
+		writeJSON(w, http.StatusOK, jobs)
+	case len(parts) == 1 && parts[0] == "jobs" && r.Method == http.MethodPost:
+		b.submitHTTP(w, r)
+	case len(parts) == 1 && parts[0] == "shutdown" && r.Method == http.MethodPost:
+		writeJSON(w, http.StatusOK, struct{}{})
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		b.KillEngine(true, new(bool))
+	case (len(parts) == 2 || len(parts) == 3) && parts[0] == "jobs":
+		id, err := strconv.Atoi(parts[1])
+		if err != nil {
+			writeError(w, http.StatusNotFound, errors.New("no job with ID "+parts[1]))
+			return
+		}
+		if _, err = b.job(id); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		action := ""
+		if len(parts) == 3 {
+			action = parts[2]
+		}
+		b.jobHTTP(w, r, stubs.JobArgs{Job: id}, action)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint "+r.Method+" "+r.URL.Path))
+	}
+}
+
+// submitHTTP submits the PGM image in the request body as a new job.
+func (b *Broker) submitHTTP(w http.ResponseWriter, r *http.Request) {
+	world, err := netpbm.Decode(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	args := stubs.GolArgs{World: world, Width: world.Width, Height: world.Height, Threads: 1}
+	query := r.URL.Query()
+	args.Image = query.Get("image")
+	if args.Image == "" {
+		args.Image = strconv.Itoa(world.Width) + "x" + strconv.Itoa(world.Height)
+	}
+	for name, value := range map[string]*int{"turns": &args.Turns, "threads": &args.Threads} {
+		if s := query.Get(name); s != "" {
+			if *value, err = strconv.Atoi(s); err != nil || *value < 0 {
+				writeError(w, http.StatusBadRequest, errors.New("bad "+name+" "+strconv.Quote(s)))
+				return
+			}
+		}
+	}
+	if s := query.Get("rule"); s != "" {
+		if args.Rule, err = util.ParseRule(s); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if s := query.Get("hashlife"); s != "" {
+		if args.HashLife, err = strconv.ParseBool(s); err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("bad hashlife "+strconv.Quote(s)))
+			return
+		}
+	}
+
+	var id int
+	if err = b.SubmitJob(args, &id); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, struct {
+		ID int `json:"id"`
+	}{id})
+}
+
+// jobMethods is the HTTP method each action on a job needs.
+var jobMethods = map[string]string{
+	"":         http.MethodGet,
+	"tick":     http.MethodGet,
+	"pause":    http.MethodPost,
+	"resume":   http.MethodPost,
+	"snapshot": http.MethodGet,
+	"result":   http.MethodGet,
+	"collect":  http.MethodPost,
+	"live":     http.MethodGet,
+}
+
+// jobHTTP carries out action on a job, with an empty action asking for its status.
+func (b *Broker) jobHTTP(w http.ResponseWriter, r *http.Request, job stubs.JobArgs, action string) {
+	method, ok := jobMethods[action]
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("no such endpoint "+r.Method+" "+r.URL.Path))
+		return
+	}
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, errors.New(r.URL.Path+" needs a "+method+" request"))
+		return
+	}
+	if action == "live" {
+		b.liveHTTP(w, r, job)
+		return
+	}
+
+	var err error
+	var res interface{}
+	switch action {
+	case "":
+		status := new(stubs.EngineStatus)
+		err = b.CheckStatus(job, status)
+		res = status
+	case "tick":
+		tick := new(stubs.TickReport)
+		err = b.DoTick(job, tick)
+		res = tick
+	case "pause":
+		status := new(stubs.EngineStatus)
+		err = b.PauseEngine(job, status)
+		res = status
+	case "resume":
+		status := new(stubs.EngineStatus)
+		err = b.ResumeEngine(job, status)
+		res = status
+	case "collect":
+		err = b.CollectJob(job, new(bool))
+		res = struct{}{}
+	case "snapshot", "result":
+		world := new(stubs.GolAliveCells)
+		if action == "snapshot" {
+			err = b.InterruptEngine(job, world)
+		} else {
+			err = b.AwaitJob(job, world)
+		}
+		if err == nil && r.URL.Query().Get("format") == "pgm" {
+			w.Header().Set("Content-Type", "image/x-portable-graymap")
+			netpbm.Encode(w, world.World)
+			return
+		}
+		res = worldResponse{
+			Turn:       world.TurnsComplete,
+			Width:      world.World.Width,
+			Height:     world.World.Height,
+			AliveCount: world.World.AliveCount(),
+			Alive:      cellResponses(world.World.AliveCells()),
+		}
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
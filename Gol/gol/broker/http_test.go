@@ -0,0 +1,218 @@
+package broker
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol/netpbm"
+	"uk.ac.bris.cs/gameoflife/gol/stubs"
+	"uk.ac.bris.cs/gameoflife/gol/testworld"
+	"uk.ac.bris.cs/gameoflife/gol/websocket"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// call makes a request to the HTTP API, decoding the JSON response into res if it isn't nil.
+func call(t *testing.T, method, url string, res interface{}) int {
+	req, err := http.NewRequest(method, url, nil)
+	util.Check(err)
+	resp, err := http.DefaultClient.Do(req)
+	util.Check(err)
+	defer resp.Body.Close()
+	if res != nil {
+		util.Check(json.NewDecoder(resp.Body).Decode(res))
+	}
+	return resp.StatusCode
+}
+
+// responseCells converts the alive cells of a worldResponse back into cells.
+func responseCells(alive []cellResponse) []util.Cell {
+	cells := make([]util.Cell, len(alive))
+	for i, cell := range alive {
+		cells[i] = util.Cell{X: cell.X, Y: cell.Y}
+	}
+	return cells
+}
+
+// TestHTTP drives a job through the HTTP API from an uploaded image to its final world, then shuts the broker down.
+func TestHTTP(t *testing.T) {
+	b := startTestBroker(t)
+	startTestEngines(t, b, 2)
+	util.Check(b.StartHTTP("127.0.0.1:0"))
+	api := "http://" + b.HTTPAddr()
+
+	image, err := os.Open("../../images/64x64.pgm")
+	util.Check(err)
+	defer image.Close()
+	resp, err := http.Post(api+"/jobs?turns=100000000&threads=2&rule=B3/S23", "image/x-portable-graymap", image)
+	util.Check(err)
+	var submitted struct{ ID int }
+	util.Check(json.NewDecoder(resp.Body).Decode(&submitted))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected the job to be created, got status %d", resp.StatusCode)
+	}
+	job := api + "/jobs/" + strconv.Itoa(submitted.ID)
+
+	var jobs []stubs.JobInfo
+	call(t, http.MethodGet, api+"/jobs", &jobs)
+	if len(jobs) != 1 || jobs[0].ID != submitted.ID || jobs[0].Image != "64x64" || jobs[0].Turns != 100000000 {
+		t.Fatalf("expected the submitted job to be listed, got %+v", jobs)
+	}
+
+	tick := new(stubs.TickReport)
+	for tick.Turns == 0 {
+		time.Sleep(10 * time.Millisecond)
+		call(t, http.MethodGet, job+"/tick", tick)
+	}
+
+	status := new(stubs.EngineStatus)
+	call(t, http.MethodPost, job+"/pause", status)
+	if !status.Paused || !status.Working {
+		t.Errorf("expected the job to be paused, got %+v", status)
+	}
+	var snapshot worldResponse
+	call(t, http.MethodGet, job+"/snapshot", &snapshot)
+	if snapshot.Turn != status.Turn || snapshot.Width != 64 || len(snapshot.Alive) != snapshot.AliveCount {
+		t.Errorf("expected a snapshot at turn %d, got one at turn %d with %d of %d alive cells", status.Turn, snapshot.Turn, len(snapshot.Alive), snapshot.AliveCount)
+	}
+	// Decoding into worldResponse would match the keys whatever their case, so they are checked as sent.
+	var raw struct{ Alive []map[string]int }
+	call(t, http.MethodGet, job+"/snapshot", &raw)
+	for _, cell := range raw.Alive {
+		_, x := cell["x"]
+		_, y := cell["y"]
+		if !x || !y || len(cell) != 2 {
+			t.Fatalf(`expected alive cells to be sent as {"x": ..., "y": ...}, got %v`, cell)
+		}
+	}
+	resp, err = http.Get(job + "/snapshot?format=pgm")
+	util.Check(err)
+	world, err := netpbm.Decode(resp.Body)
+	resp.Body.Close()
+	util.Check(err)
+	testworld.AssertEqualCells(t, world.AliveCells(), responseCells(snapshot.Alive))
+
+	if code := call(t, http.MethodPost, job+"/collect", nil); code != http.StatusInternalServerError {
+		t.Errorf("expected collecting a running job to be refused, got status %d", code)
+	}
+	call(t, http.MethodPost, job+"/resume", status)
+	call(t, http.MethodGet, job, status)
+	if status.Paused {
+		t.Errorf("expected the job to be resumed, got %+v", status)
+	}
+
+	if code := call(t, http.MethodGet, job+"/pause", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected pausing with GET to be refused, got status %d", code)
+	}
+	var failed struct{ Error string }
+	if code := call(t, http.MethodGet, api+"/jobs/42", &failed); code != http.StatusNotFound || failed.Error == "" {
+		t.Errorf("expected an unknown job to be reported, got status %d and error %q", code, failed.Error)
+	}
+
+	call(t, http.MethodPost, api+"/shutdown", nil)
+	select {
+	case <-b.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not stop the broker")
+	}
+}
+
+// TestHTTPResult submits a short job over the HTTP API and collects its final world.
+func TestHTTPResult(t *testing.T) {
+	b := startTestBroker(t)
+	startTestEngines(t, b, 2)
+	util.Check(b.StartHTTP("127.0.0.1:0"))
+	api := "http://" + b.HTTPAddr()
+
+	image, err := os.Open("../../images/64x64.pgm")
+	util.Check(err)
+	defer image.Close()
+	resp, err := http.Post(api+"/jobs?turns=100", "image/x-portable-graymap", image)
+	util.Check(err)
+	var submitted struct{ ID int }
+	util.Check(json.NewDecoder(resp.Body).Decode(&submitted))
+	resp.Body.Close()
+
+	job := api + "/jobs/" + strconv.Itoa(submitted.ID)
+	var result worldResponse
+	call(t, http.MethodGet, job+"/result", &result)
+	if result.Turn != 100 {
+		t.Errorf("expected the result after 100 turns, got turn %d", result.Turn)
+	}
+	expected := testworld.Read(t, "../../check/images/64x64x100.pgm").AliveCells()
+	testworld.AssertEqualCells(t, responseCells(result.Alive), expected)
+
+	// The job is kept until it is collected, so the result can be asked for again.
+	if code := call(t, http.MethodGet, job+"/result", &result); code != http.StatusOK || result.Turn != 100 {
+		t.Errorf("expected the result to be kept until collected, got status %d at turn %d", code, result.Turn)
+	}
+	call(t, http.MethodPost, job+"/collect", nil)
+	if code := call(t, http.MethodGet, job, nil); code != http.StatusNotFound {
+		t.Errorf("expected the job to be forgotten once collected, got status %d", code)
+	}
+
+	if code := call(t, http.MethodPost, api+"/jobs?turns=10", nil); code != http.StatusBadRequest {
+		t.Errorf("expected a job without an image to be refused, got status %d", code)
+	}
+}
+
+// TestLiveView follows a job through the live view's WebSocket, checking its messages add up to the final world.
+func TestLiveView(t *testing.T) {
+	b := startTestBroker(t)
+	startTestEngines(t, b, 2)
+	util.Check(b.StartHTTP("127.0.0.1:0"))
+
+	resp, err := http.Get("http://" + b.HTTPAddr() + "/")
+	util.Check(err)
+	page, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	util.Check(err)
+	if resp.StatusCode != http.StatusOK || string(page) != livePage {
+		t.Errorf("expected the live view to be served, got status %d", resp.StatusCode)
+	}
+
+	var id int
+	args := stubs.GolArgs{World: testworld.Read(t, "../../images/64x64.pgm"), Width: 64, Height: 64, Turns: 100, Threads: 2}
+	util.Check(b.SubmitJob(args, &id))
+	conn, err := websocket.Dial(b.HTTPAddr(), "/jobs/"+strconv.Itoa(id)+"/live")
+	util.Check(err)
+	defer conn.Close()
+
+	world := util.NewBitGrid(64, 64)
+	for first := true; ; first = false {
+		_, message, err := conn.ReadMessage()
+		util.Check(err)
+		if first != (message[1] == 1) {
+			t.Errorf("expected only the first message to be from an empty board")
+		}
+		turn := int(binary.LittleEndian.Uint32(message[4:]))
+		aliveCount := int(binary.LittleEndian.Uint32(message[8:]))
+		if message[0] == 1 {
+			for i := range world.Words {
+				world.Words[i] ^= binary.LittleEndian.Uint64(message[24+8*i:])
+			}
+		} else {
+			for offset := 24; offset < len(message); offset += 4 {
+				i := int(binary.LittleEndian.Uint32(message[offset:]))
+				world.Set(i%64, i/64, !world.Get(i%64, i/64))
+			}
+		}
+		if world.AliveCount() != aliveCount {
+			t.Fatalf("turn %d: the board has %d alive cells, the message said %d", turn, world.AliveCount(), aliveCount)
+		}
+		if binary.LittleEndian.Uint32(message[20:]) == 0 {
+			if turn != 100 {
+				t.Errorf("expected the last message at turn 100, got turn %d", turn)
+			}
+			break
+		}
+	}
+	expected := testworld.Read(t, "../../check/images/64x64x100.pgm").AliveCells()
+	testworld.AssertEqualCells(t, world.AliveCells(), expected)
+}
@@ -0,0 +1,447 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+	"uk.ac.bris.cs/gameoflife/gol/hashlife"
+	"uk.ac.bris.cs/gameoflife/gol/stubs"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// job is one simulation submitted to the broker. Every job has its own world, turn and lock,
+// and its own strips on the engines, so one broker can run several jobs at once.
+type job struct {
+	id         int
+	image      string
+	started    time.Time
+	broker     *Broker
+	m          sync.Mutex
+	turn       int
+	turns      int
+	width      int
+	height     int
+	threads    int
+	rule       util.Rule
+	hashLife   bool
+	working    bool
+	aliveCount int
+
+	// A paused job waits on resumed before each turn. The pause only lasts as long as its lease,
+	// which the controller renews while it stays paused, so a controller going away can't leave it stuck.
+	paused  bool
+	pauses  int // counts pauses and renewals, so a lease which has since been renewed doesn't resume the job
+	lease   *time.Timer
+	resumed *sync.Cond
+
+	// The broker only holds the whole world as a snapshot, engines keep their own strips between turns.
+	// If an engine fails the job rolls back to the snapshot and replays the turns since it was taken.
+	snapshot     util.BitGrid
+	snapshotTurn int
+
+	assignments   []assignment
+	distributedTo []*engine // every engine active at the last distribute, including any given no rows
+
+	// frames are the worlds most recently sent to controllers watching the job, so the next diff each one
+	// asks for can be taken from the world it already has. turned is broadcast on m whenever the turn changes
+	// and when the job finishes, waking anything waiting for the job to move on. While watchers are waiting
+	// the world is pulled back from the engines every turn, so a watcher which keeps up sees every turn.
+	frames   []frame
+	turned   *sync.Cond
+	watchers int
+
+	checkpointTurn int
+	checkpointTime time.Time
+	recovered      bool // whether the job was resumed from a checkpoint when the broker started
+	recoveredTurn  int
+
+	err  error
+	done chan struct{} // closed once the job has finished, successfully or not
+}
+
+// frame is the world of a job at a turn.
+type frame struct {
+	turn  int
+	world util.BitGrid
+}
+
+// maxFrames is how many frames a job keeps for controllers to take diffs from.
+const maxFrames = 4
+
+func newJob(b *Broker, id int, args stubs.GolArgs) *job {
+	world := args.World
+	j := &job{
+		id:         id,
+		image:      args.Image,
+		started:    time.Now(),
+		broker:     b,
+		turns:      args.Turns,
+		width:      args.Width,
+		height:     args.Height,
+		threads:    args.Threads,
+		rule:       args.Rule.OrDefault(),
+		hashLife:   args.HashLife,
+		working:    true,
+		aliveCount: world.AliveCount(), // initialise with current alive for 0 turn tests
+		snapshot:   world,
+		done:       make(chan struct{}),
+	}
+	j.resumed = sync.NewCond(&j.m)
+	j.turned = sync.NewCond(&j.m)
+	j.frames = []frame{{0, world}}
+	return j
+}
+
+// recoverJob rebuilds a job from its checkpoint, ready to carry on from the turn the checkpoint was taken at.
+func recoverJob(b *Broker, c checkpoint) *job {
+	j := newJob(b, c.Job, stubs.GolArgs{Image: c.Image, World: c.World, Width: c.Width, Height: c.Height, Turns: c.Turns, Threads: c.Threads, Rule: c.Rule, HashLife: c.HashLife})
+	j.started = c.Started
+	j.turn = c.Turn
+	j.snapshotTurn = c.Turn
+	j.checkpointTurn = c.Turn
+	j.checkpointTime = time.Now()
+	j.recovered = true
+	j.recoveredTurn = c.Turn
+	j.frames = []frame{{c.Turn, c.World}}
+	return j
+}
+
+// pause stops the job before its next turn, or renews the lease of a job which is already paused.
+// If the lease isn't renewed within lease the job resumes by itself. Called with j.m held.
+func (j *job) pause(lease time.Duration) {
+	if j.lease != nil {
+		j.lease.Stop()
+	}
+	j.paused = true
+	j.pauses++
+	pause := j.pauses
+	j.lease = time.AfterFunc(lease, func() {
+		j.m.Lock()
+		defer j.m.Unlock()
+		if j.paused && j.pauses == pause {
+			fmt.Println("Job " + strconv.Itoa(j.id) + ": pause lease expired, resuming from turn: " + strconv.Itoa(j.turn))
+			j.resume()
+		}
+	})
+}
+
+// resume lets a paused job carry on. It does nothing if the job isn't paused. Called with j.m held.
+func (j *job) resume() {
+	if j.lease != nil {
+		j.lease.Stop()
+		j.lease = nil
+	}
+	j.paused = false
+	j.resumed.Broadcast()
+}
+
+// waitWhilePaused blocks until the job isn't paused. Called with j.m held.
+func (j *job) waitWhilePaused() {
+	for j.paused {
+		j.resumed.Wait()
+	}
+}
+
+// diff returns the change in the job's world since the frame for turn since, or from an empty world if there's
+// no such frame, and keeps the world it was taken to as a frame. The diff is taken to the latest snapshot, which
+// is taken every turn while anyone is waiting in watch. Turns which pass while nobody is waiting, because the
+// watcher is still busy with the last diff, are coalesced into the next one. Called with j.m held.
+func (j *job) diff(since int) stubs.WorldDiff {
+	from := util.NewBitGrid(j.width, j.height)
+	reset := true
+	for _, f := range j.frames {
+		if f.turn == since {
+			from = f.world
+			reset = false
+		}
+	}
+	d := stubs.NewWorldDiff(from, j.snapshot)
+	d.From = since
+	d.Turn = j.snapshotTurn
+	d.Reset = reset
+	d.Working = j.working
+	d.AliveCount = j.snapshot.AliveCount()
+
+	if last := j.frames[len(j.frames)-1]; last.turn != j.snapshotTurn {
+		j.frames = append(j.frames, frame{j.snapshotTurn, j.snapshot})
+		if len(j.frames) > maxFrames {
+			j.frames = j.frames[1:]
+		}
+	}
+	return d
+}
+
+// watch waits until the job has a snapshot from after turn since, or has finished, then returns the diff
+// since that turn.
+func (j *job) watch(since int) stubs.WorldDiff {
+	j.m.Lock()
+	defer j.m.Unlock()
+	j.watchers++
+	for j.working && j.snapshotTurn <= since {
+		j.turned.Wait()
+	}
+	j.watchers--
+	return j.diff(since)
+}
+
+// info describes the job for ListJobs.
+func (j *job) info() stubs.JobInfo {
+	j.m.Lock()
+	defer j.m.Unlock()
+	return stubs.JobInfo{ID: j.id, Image: j.image, Width: j.width, Height: j.height, Turns: j.turns, Turn: j.turn, Working: j.working, Paused: j.paused, Started: j.started}
+}
+
+// run processes every turn of the job, then frees its strips on the engines.
+func (j *job) run() {
+	if j.broker.CheckpointDir != "" && !j.recovered {
+		j.m.Lock()
+		j.checkpoint()
+		j.m.Unlock()
+	}
+	if j.recovered && !j.hashLife && j.turn < j.turns {
+		j.broker.waitForEngines()
+	}
+
+	var err error
+	switch {
+	case j.turn == j.turns && j.recovered:
+		// The job finished before the broker stopped, but its result was never collected.
+	case j.hashLife:
+		j.processHashLife()
+	default:
+		err = j.processDistributed()
+	}
+
+	for _, e := range j.distributedTo {
+		j.broker.callEngine(e, stubs.DropStrip, stubs.JobArgs{Job: j.id}, new(bool))
+	}
+
+	j.m.Lock()
+	// The final world is checkpointed too, so if the broker stops before the result is collected
+	// recovering the job returns it rather than replaying turns from an older checkpoint.
+	if err == nil && j.broker.CheckpointDir != "" && j.checkpointTurn != j.turn {
+		j.checkpoint()
+	}
+	j.working = false
+	j.err = err
+	j.turned.Broadcast()
+	j.m.Unlock()
+	close(j.done)
+	fmt.Println("Job " + strconv.Itoa(j.id) + ": finished at turn " + strconv.Itoa(j.turn) + " with " + strconv.Itoa(j.aliveCount) + " Alive Cells")
+}
+
+// forEachAssignment runs f concurrently for every assignment, dropping the engines it fails for.
+func (j *job) forEachAssignment(f func(i int, a assignment) error) error {
+	errs := make([]error, len(j.assignments))
+	var wg sync.WaitGroup
+	for i, a := range j.assignments {
+		wg.Add(1)
+		go func(i int, a assignment) {
+			defer wg.Done()
+			errs[i] = f(i, a)
+		}(i, a)
+	}
+	wg.Wait()
+
+	var failed error
+	for i, err := range errs {
+		if err != nil {
+			j.broker.dropEngine(j.assignments[i].engine, err)
+			failed = err
+		}
+	}
+	return failed
+}
+
+// distribute splits world across the active engines, each of which keeps its strip until the next distribute.
+func (j *job) distribute(world util.BitGrid) error {
+	for {
+		active := j.broker.activeEngines()
+		if len(active) == 0 {
+			return errors.New("no GOL Engines are registered with the broker")
+		}
+
+		capacities := make([]int, len(active))
+		for i, e := range active {
+			capacities[i] = e.capacity
+		}
+
+		j.assignments = nil
+		for i, s := range partition(j.height, capacities) {
+			if s.height == 0 {
+				continue
+			}
+			rows := world.Rows(s.offset, s.offset+s.height)
+			j.assignments = append(j.assignments, assignment{engine: active[i], strip: s, top: rows.Row(0), bottom: rows.Row(rows.Height - 1)})
+		}
+		j.distributedTo = active
+
+		err := j.forEachAssignment(func(_ int, a assignment) error {
+			args := stubs.StripArgs{Job: j.id, Offset: a.strip.offset, Strip: world.Rows(a.strip.offset, a.strip.offset+a.strip.height), Threads: j.threads, Rule: j.rule}
+			return j.broker.callEngine(a.engine, stubs.LoadStrip, args, new(bool))
+		})
+		if err == nil {
+			fmt.Println("Job " + strconv.Itoa(j.id) + ": distributed world across " + strconv.Itoa(len(j.assignments)) + " Engines")
+			return nil
+		}
+	}
+}
+
+// gather pulls every strip back from the engines to rebuild the whole world.
+func (j *job) gather() (util.BitGrid, error) {
+	world := util.NewBitGrid(j.width, j.height)
+	err := j.forEachAssignment(func(_ int, a assignment) error {
+		response := new(stubs.StripArgs)
+		err := j.broker.callEngine(a.engine, stubs.GetStrip, stubs.JobArgs{Job: j.id}, response)
+		if err == nil {
+			copy(world.Rows(a.strip.offset, a.strip.offset+a.strip.height).Words, response.Strip.Words)
+		}
+		return err
+	})
+	return world, err
+}
+
+// rollback restarts the job from the last snapshot on whichever engines are still alive.
+func (j *job) rollback() error {
+	fmt.Println("Job " + strconv.Itoa(j.id) + ": rolling back from turn " + strconv.Itoa(j.turn) + " to snapshot at turn " + strconv.Itoa(j.snapshotTurn))
+	j.turn = j.snapshotTurn
+	j.aliveCount = j.snapshot.AliveCount()
+	return j.distribute(j.snapshot)
+}
+
+// syncWorld pulls the current world from the engines, recording it as the latest snapshot.
+// If an engine has failed the job is rolled back to the previous snapshot instead.
+func (j *job) syncWorld() error {
+	if j.snapshotTurn == j.turn {
+		return nil
+	}
+	world, err := j.gather()
+	if err != nil {
+		return j.rollback()
+	}
+	j.snapshot = world
+	j.snapshotTurn = j.turn
+	return nil
+}
+
+// membershipChanged reports whether engines have registered or deregistered since the world was distributed.
+func (j *job) membershipChanged() bool {
+	active := j.broker.activeEngines()
+	if len(active) != len(j.distributedTo) {
+		return true
+	}
+	for i, e := range j.distributedTo {
+		if active[i] != e {
+			return true
+		}
+	}
+	return false
+}
+
+// processTurn has every engine compute one turn of its strip, swapping only the halo rows between them.
+func (j *job) processTurn() error {
+	if j.membershipChanged() {
+		if err := j.syncWorld(); err != nil {
+			return err
+		}
+		// If an engine failed while the world was pulled back, syncWorld has already rolled back and
+		// redistributed it across the engines which are left.
+		if j.membershipChanged() {
+			if err := j.distribute(j.snapshot); err != nil {
+				return err
+			}
+		}
+	}
+
+	responses := make([]stubs.EngineResponse, len(j.assignments))
+	err := j.forEachAssignment(func(i int, a assignment) error {
+		above := j.assignments[(i-1+len(j.assignments))%len(j.assignments)]
+		below := j.assignments[(i+1)%len(j.assignments)]
+		args := stubs.EngineArgs{Job: j.id, Top: above.bottom, Bottom: below.top}
+		return j.broker.callEngine(a.engine, stubs.ProcessTurn, args, &responses[i])
+	})
+	if err != nil {
+		return j.rollback()
+	}
+
+	j.aliveCount = 0
+	for i, response := range responses {
+		j.assignments[i].top = response.Top
+		j.assignments[i].bottom = response.Bottom
+		j.aliveCount += response.AliveCount
+	}
+	j.turn++
+
+	if j.turn%j.broker.SnapshotInterval == 0 {
+		return j.syncWorld()
+	}
+	return nil
+}
+
+// processDistributed runs the job across the engines until every turn is done.
+func (j *job) processDistributed() (err error) {
+	if j.turns > 0 {
+		j.m.Lock()
+		err = j.distribute(j.snapshot)
+		j.m.Unlock()
+	}
+
+	for err == nil {
+		for j.turn < j.turns && err == nil {
+			j.m.Lock()
+			j.waitWhilePaused()
+			err = j.processTurn()
+			if err == nil && j.watchers > 0 {
+				err = j.syncWorld()
+			}
+			j.turned.Broadcast()
+			if err == nil && j.checkpointDue() {
+				if err = j.syncWorld(); err == nil && j.snapshotTurn == j.turn {
+					j.checkpoint()
+				}
+			}
+			if j.turn%50 == 0 {
+				fmt.Println("Job " + strconv.Itoa(j.id) + ": finished processing turn: " + strconv.Itoa(j.turn) + " with " + strconv.Itoa(j.aliveCount) + " Alive Cells")
+			}
+			j.m.Unlock()
+		}
+
+		// An engine can fail while the final world is being pulled back, leaving some turns to replay.
+		j.m.Lock()
+		if err == nil {
+			err = j.syncWorld()
+		}
+		done := j.turn == j.turns
+		j.m.Unlock()
+		if done {
+			break
+		}
+	}
+	return
+}
+
+// processHashLife runs the job on the broker itself with HashLife instead of on the engines.
+// The world is published after every jump, so DoTick and InterruptEngine report the turn it has reached.
+func (j *job) processHashLife() {
+	life := hashlife.New(j.snapshot, j.rule)
+	for j.turn < j.turns {
+		j.m.Lock()
+		j.waitWhilePaused()
+		j.m.Unlock()
+		advanced := life.Advance(j.turns - j.turn)
+
+		j.m.Lock()
+		j.turn += advanced
+		j.snapshot = life.World()
+		j.snapshotTurn = j.turn
+		j.aliveCount = j.snapshot.AliveCount()
+		j.turned.Broadcast()
+		fmt.Println("Job " + strconv.Itoa(j.id) + ": HashLife jumped " + strconv.Itoa(advanced) + " turns to turn: " + strconv.Itoa(j.turn) + " with " + strconv.Itoa(j.aliveCount) + " Alive Cells")
+		if j.checkpointDue() {
+			j.checkpoint()
+		}
+		j.m.Unlock()
+	}
+}
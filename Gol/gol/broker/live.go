@@ -0,0 +1,196 @@
+package broker
+
+import (
+	"encoding/binary"
+	"net/http"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol/stubs"
+	"uk.ac.bris.cs/gameoflife/gol/websocket"
+)
+
+// liveInterval is the least time left between the messages streamed to a live view.
+const liveInterval = 100 * time.Millisecond
+
+// liveReadLimit is the longest message a live view may send. It never sends any, so this only bounds what a
+// misbehaving client can make the broker read.
+const liveReadLimit = 1024
+
+// encodeLive packs a diff into a binary message for the live view, with every number little-endian:
+//
+//	byte 0     0 if the flipped cells follow as uint32 indices y*width+x, 1 if they follow as a mask
+//	byte 1     1 if the diff is from an empty board rather than from the last message
+//	bytes 2-3  unused
+//	uint32s    turn, alive count, width, height, 1 if the job is still working
+//
+// The mask is the world's rows of 64-bit words, in which bit x%64 of word x/64 is the cell in column x.
+func encodeLive(d stubs.WorldDiff) []byte {
+	header := make([]byte, 24)
+	if d.Mask.Words != nil {
+		header[0] = 1
+	}
+	if d.Reset {
+		header[1] = 1
+	}
+	working := 0
+	if d.Working {
+		working = 1
+	}
+	for i, v := range []int{d.Turn, d.AliveCount, d.Width, d.Height, working} {
+		binary.LittleEndian.PutUint32(header[4+4*i:], uint32(v))
+	}
+
+	if d.Mask.Words != nil {
+		message := append(header, make([]byte, 8*len(d.Mask.Words))...)
+		for i, word := range d.Mask.Words {
+			binary.LittleEndian.PutUint64(message[24+8*i:], word)
+		}
+		return message
+	}
+	message := append(header, make([]byte, 4*len(d.Flipped))...)
+	for i, index := range d.Flipped {
+		binary.LittleEndian.PutUint32(message[24+4*i:], index)
+	}
+	return message
+}
+
+// liveHTTP streams a job to a live view over a WebSocket. The first message is the whole board, and after that
+// each message is the diff since the last, until the job finishes or the view goes away.
+func (b *Broker) liveHTTP(w http.ResponseWriter, r *http.Request, job stubs.JobArgs) {
+	j, err := b.job(job.Job)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	conn, err := websocket.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	conn.SetReadLimit(liveReadLimit)
+
+	// The view never sends anything, but reading notices it closing.
+	closed := make(chan struct{})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				close(closed)
+				return
+			}
+		}
+	}()
+
+	since := -1
+	for {
+		d := j.watch(since)
+		if conn.WriteMessage(websocket.Binary, encodeLive(d)) != nil || !d.Working {
+			return
+		}
+		since = d.Turn
+		select {
+		case <-closed:
+			return
+		case <-time.After(liveInterval):
+		}
+	}
+}
+
+// livePage is the live view, served at the root of the HTTP API. It lists the jobs on the broker and draws
+// whichever is picked in the URL's fragment, e.g. /#3 for job 3, on a canvas.
+const livePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Game of Life</title>
+<style>
+body { background: #222; color: #eee; font-family: sans-serif; margin: 1em; }
+a { color: #8cf; margin-right: 1em; }
+canvas { image-rendering: pixelated; border: 1px solid #555; max-width: 95vw; max-height: 80vh; }
+</style>
+</head>
+<body>
+<div id="jobs">Jobs: </div>
+<p id="status">Pick a job to watch.</p>
+<canvas id="board" width="0" height="0"></canvas>
+<script>
+const board = document.getElementById("board");
+const statusLine = document.getElementById("status");
+let socket = null;
+
+function listJobs() {
+	fetch("/jobs").then(r => r.json()).then(jobs => {
+		const list = document.getElementById("jobs");
+		list.textContent = jobs.length ? "Jobs: " : "No jobs on the broker.";
+		for (const job of jobs) {
+			const link = document.createElement("a");
+			link.href = "#" + job.id;
+			link.textContent = job.id + ": " + job.image + " (" + job.turn + "/" + job.turns + ")";
+			list.appendChild(link);
+		}
+	});
+}
+
+function watch(id) {
+	if (socket) {
+		socket.close();
+	}
+	let cells = null, image = null, context = null;
+	socket = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/jobs/" + id + "/live");
+	socket.binaryType = "arraybuffer";
+	socket.onmessage = event => {
+		const view = new DataView(event.data);
+		const mask = view.getUint8(0) === 1, reset = view.getUint8(1) === 1;
+		const turn = view.getUint32(4, true), alive = view.getUint32(8, true);
+		const width = view.getUint32(12, true), height = view.getUint32(16, true);
+		const working = view.getUint32(20, true) === 1;
+		if (cells === null || reset || board.width !== width || board.height !== height) {
+			board.width = width;
+			board.height = height;
+			context = board.getContext("2d");
+			image = context.createImageData(width, height);
+			cells = new Uint8Array(width * height);
+			for (let i = 3; i < image.data.length; i += 4) {
+				image.data[i] = 255;
+			}
+		}
+		const flip = i => {
+			cells[i] ^= 1;
+			const shade = cells[i] ? 255 : 0;
+			image.data[4 * i] = image.data[4 * i + 1] = image.data[4 * i + 2] = shade;
+		};
+		if (mask) {
+			const rowBytes = Math.ceil(width / 64) * 8;
+			for (let y = 0; y < height; y++) {
+				for (let x = 0; x < width; x++) {
+					if (view.getUint8(24 + y * rowBytes + (x >> 3)) >> (x & 7) & 1) {
+						flip(y * width + x);
+					}
+				}
+			}
+		} else {
+			for (let offset = 24; offset < event.data.byteLength; offset += 4) {
+				flip(view.getUint32(offset, true));
+			}
+		}
+		context.putImageData(image, 0, 0);
+		statusLine.textContent = "Job " + id + ": turn " + turn + ", " + alive + " alive cells" + (working ? "" : ", finished");
+	};
+	socket.onclose = () => listJobs();
+}
+
+window.onhashchange = () => watch(location.hash.slice(1));
+listJobs();
+if (location.hash.length > 1) {
+	watch(location.hash.slice(1));
+}
+</script>
+</body>
+</html>
+`
+
+// pageHTTP serves the live view.
+func pageHTTP(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(livePage))
+}
@@ -0,0 +1,123 @@
+package broker
+
+import (
+	"fmt"
+	"math/rand"
+	"net/rpc"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol/stubs"
+	"uk.ac.bris.cs/gameoflife/gol/testworld"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// assertCovers checks the strips cover rows 0 to height-1 exactly once, in order.
+func assertCovers(t *testing.T, strips []strip, height int) {
+	offset := 0
+	for i, s := range strips {
+		if s.offset != offset || s.height < 0 {
+			t.Fatalf("strip %d is %+v, expected it to start at row %d", i, s, offset)
+		}
+		offset += s.height
+	}
+	if offset != height {
+		t.Fatalf("strips cover %d rows, expected %d", offset, height)
+	}
+}
+
+// TestPartition checks equal and weighted partitions of odd heights leave no rows out.
+func TestPartition(t *testing.T) {
+	for _, height := range []int{127, 131} {
+		for engineCount := 2; engineCount <= 7; engineCount++ {
+			t.Run(fmt.Sprintf("%d-%d", height, engineCount), func(t *testing.T) {
+				equal := make([]int, engineCount)
+				weighted := make([]int, engineCount)
+				for i := range equal {
+					equal[i] = 4
+					weighted[i] = i + 1
+				}
+
+				strips := partition(height, equal)
+				assertCovers(t, strips, height)
+				for _, s := range strips {
+					if s.height != height/engineCount && s.height != height/engineCount+1 {
+						t.Errorf("equal capacities gave uneven strips %+v", strips)
+					}
+				}
+
+				strips = partition(height, weighted)
+				assertCovers(t, strips, height)
+				total := engineCount * (engineCount + 1) / 2
+				for i, s := range strips {
+					share := float64(height*weighted[i]) / float64(total)
+					if float64(s.height) < share-1 || float64(s.height) > share+1 {
+						t.Errorf("engine with capacity %d given %d rows, expected about %.1f", weighted[i], s.height, share)
+					}
+				}
+			})
+		}
+	}
+
+	strips := partition(3, []int{1, 1, 1, 1, 1})
+	assertCovers(t, strips, 3)
+}
+
+// nextWorld is a straightforward reference implementation of one turn on a closed domain.
+func nextWorld(world [][]byte) [][]byte {
+	height, width := len(world), len(world[0])
+	next := make([][]byte, height)
+	for y := range next {
+		next[y] = make([]byte, width)
+		for x := range next[y] {
+			neighbours := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if (dy != 0 || dx != 0) && world[(y+dy+height)%height][(x+dx+width)%width] == 0xff {
+						neighbours++
+					}
+				}
+			}
+			if neighbours == 3 || (neighbours == 2 && world[y][x] == 0xff) {
+				next[y][x] = 0xff
+			}
+		}
+	}
+	return next
+}
+
+// TestOddSizes runs a 127x131 world through 2 to 7 engines and compares it with the reference implementation.
+func TestOddSizes(t *testing.T) {
+	const width, height, turns = 127, 131, 20
+	random := rand.New(rand.NewSource(1))
+	world := make([][]byte, height)
+	for y := range world {
+		world[y] = make([]byte, width)
+		for x := range world[y] {
+			if random.Intn(3) == 0 {
+				world[y][x] = 0xff
+			}
+		}
+	}
+	expected := world
+	for i := 0; i < turns; i++ {
+		expected = nextWorld(expected)
+	}
+
+	for engineCount := 2; engineCount <= 7; engineCount++ {
+		t.Run(fmt.Sprintf("%dx%dx%d-%d", width, height, turns, engineCount), func(t *testing.T) {
+			b := startTestBroker(t)
+			startTestEngines(t, b, engineCount)
+
+			client, err := rpc.Dial("tcp", b.Addr())
+			util.Check(err)
+			defer client.Close()
+
+			args := stubs.GolArgs{World: util.BitGridFromBytes(world, width, height), Width: width, Height: height, Turns: turns}
+			response := new(stubs.GolAliveCells)
+			util.Check(client.Call(stubs.ProcessTurns, args, response))
+
+			expectedCells := util.BitGridFromBytes(expected, width, height).AliveCells()
+			testworld.AssertEqualCells(t, response.World.AliveCells(), expectedCells)
+		})
+	}
+}
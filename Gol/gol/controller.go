@@ -1,15 +1,29 @@
 package gol
 
 import (
+	"errors"
 	"fmt"
 	"net/rpc"
-	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 	"uk.ac.bris.cs/gameoflife/gol/stubs"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
+// DefaultBrokerAddr is the broker address used by the command line unless told otherwise.
+const DefaultBrokerAddr = "127.0.0.1:8030"
+
+// brokerAttempts and brokerBackoff control how hard the controller tries to reach the broker,
+// waiting brokerBackoff after the first failure and doubling the wait after each one after that.
+const brokerAttempts = 5
+const brokerBackoff = 200 * time.Millisecond
+
+// diffInterval is the least time the controller leaves between asking the broker for the change in the world,
+// so watching a fast job doesn't have the broker pull the whole world back from the engines every turn.
+const diffInterval = 50 * time.Millisecond
+
 type distributorChannels struct {
 	events     chan<- Event
 	ioCommand  chan<- ioCommand
@@ -17,26 +31,167 @@ type distributorChannels struct {
 	ioFilename chan<- string
 	ioOutput   chan<- uint8
 	ioInput    <-chan uint8
+	ioRule     <-chan util.Rule
+	ioError    <-chan error
 	keyPresses <-chan rune
 }
 
-func savePGM(p Params, c distributorChannels, aliveCells []util.Cell, turns int) {
-	c.ioCommand <- ioOutput
-	c.ioFilename <- strconv.Itoa(p.ImageWidth) + "x" + strconv.Itoa(p.ImageHeight) + "x" + strconv.Itoa(turns)
-	fmt.Println("Started saving PGM")
+// imageName names the image or pattern the world starts from, for the broker's job list and the output name.
+func imageName(p Params) string {
+	switch {
+	case p.Input != "":
+		return filepath.Base(p.Input)
+	case p.Pattern != "":
+		return p.Pattern
+	}
+	return strconv.Itoa(p.ImageWidth) + "x" + strconv.Itoa(p.ImageHeight)
+}
+
+// outputName is the name, less its extension, the world is saved under within p.OutDir after turns turns.
+// It is p.OutName with {width}, {height}, {turns} and {name}, the image's name less its extension, filled in.
+func outputName(p Params, turns int) string {
+	name := imageName(p)
+	return strings.NewReplacer(
+		"{width}", strconv.Itoa(p.ImageWidth),
+		"{height}", strconv.Itoa(p.ImageHeight),
+		"{turns}", strconv.Itoa(turns),
+		"{name}", strings.TrimSuffix(name, filepath.Ext(name)),
+	).Replace(p.OutName)
+}
+
+// savePGM has the io goroutine write world out as a PGM image as it was after turns turns.
+func savePGM(p Params, c distributorChannels, world util.BitGrid, turns int) {
+	saveFormat(p, c, world, turns, "pgm")
+}
 
-	for i := 0; i < p.ImageHeight; i++ {
-		for j := 0; j < p.ImageWidth; j++ {
+// saveFormat has the io goroutine write world out in format, pgm or one of the extensions in SaveFormats,
+// sending an ImageOutputComplete once the file has been written. The extension is always sent, as the name may
+// have dots of its own, but a PGM image is reported without it.
+func saveFormat(p Params, c distributorChannels, world util.BitGrid, turns int, format string) {
+	name := outputName(p, turns)
+	filename := name + "." + format
+	c.ioCommand <- ioOutput
+	c.ioFilename <- filename
+	for y := 0; y < p.ImageHeight; y++ {
+		for x := 0; x < p.ImageWidth; x++ {
 			var value byte = 0
-			for _, cell := range aliveCells {
-				if cell.X == j && cell.Y == i {
-					value = 255
-				}
+			if world.Get(x, y) {
+				value = 255
 			}
 			c.ioOutput <- value
 		}
 	}
-	fmt.Println("Finished saving PGM: " + strconv.Itoa(p.ImageWidth) + "x" + strconv.Itoa(p.ImageHeight) + "x" + strconv.Itoa(turns))
+
+	c.ioCommand <- ioCheckIdle
+	<-c.ioIdle
+	fmt.Println("Finished saving " + filename)
+	if format == "pgm" {
+		filename = name
+	}
+	c.events <- ImageOutputComplete{turns, filename}
+}
+
+// saveWorld saves world as a PGM image, and in each of the formats p.SaveAs lists.
+func saveWorld(p Params, c distributorChannels, world util.BitGrid, turns int) {
+	savePGM(p, c, world, turns)
+	for _, format := range p.SaveAs {
+		saveFormat(p, c, world, turns, format)
+	}
+}
+
+// dialBroker connects to the broker, retrying with exponential backoff in case it is still starting up.
+func dialBroker(addr string) (*rpc.Client, error) {
+	backoff := brokerBackoff
+	var err error
+	for attempt := 1; attempt <= brokerAttempts; attempt++ {
+		var client *rpc.Client
+		client, err = rpc.Dial("tcp", addr)
+		if err == nil {
+			return client, nil
+		}
+		fmt.Println("Failed to connect to broker (attempt " + strconv.Itoa(attempt) + "/" + strconv.Itoa(brokerAttempts) + "): " + err.Error())
+		if attempt < brokerAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return nil, fmt.Errorf("could not reach broker at %v after %v attempts: %v", addr, brokerAttempts, err)
+}
+
+// quitWithError reports an error that stops the controller before it has a job to run, and closes events.
+func quitWithError(events chan<- Event, err error) {
+	fmt.Println(err)
+	events <- ErrorOccurred{0, err}
+	events <- StateChange{0, Quitting}
+	close(events)
+}
+
+// ListJobs returns every job on the broker at brokerAddr which is running or whose result hasn't been collected.
+func ListJobs(brokerAddr string) ([]stubs.JobInfo, error) {
+	client, err := dialBroker(brokerAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	var jobs []stubs.JobInfo
+	err = client.Call(stubs.ListJobs, true, &jobs)
+	return jobs, err
+}
+
+// AttachParams fills in the size and turns of the job p attaches to, so the window and the images saved from it
+// come out right. It is called before Run, which uses the params as they are.
+func AttachParams(p Params) (Params, error) {
+	if p.BrokerAddr == "" {
+		return p, errors.New("attaching to a job needs a broker address")
+	}
+	jobs, err := ListJobs(p.BrokerAddr)
+	if err != nil {
+		return p, err
+	}
+	for _, job := range jobs {
+		if job.ID == p.AttachJob {
+			p.ImageWidth = job.Width
+			p.ImageHeight = job.Height
+			p.Turns = job.Turns
+			return p, nil
+		}
+	}
+	return p, errors.New("no job with ID " + strconv.Itoa(p.AttachJob) + " on the broker")
+}
+
+// watchJob asks the broker for the change in the job's world since the turn of the last diff, sending each diff
+// to diffs, until the job finishes or stop is closed. The next diff isn't asked for until the last has been taken,
+// so turns passing while the controller is busy are coalesced into one diff.
+func watchJob(client *rpc.Client, job int, since int, diffs chan<- stubs.WorldDiff, stop <-chan struct{}) {
+	for {
+		asked := time.Now()
+		diff := new(stubs.WorldDiff)
+		if err := client.Call(stubs.WatchJob, stubs.WatchArgs{Job: job, Since: since}, diff); err != nil {
+			return
+		}
+		select {
+		case diffs <- *diff:
+		case <-stop:
+			return
+		}
+		if !diff.Working {
+			return
+		}
+		since = diff.Turn
+		time.Sleep(diffInterval - time.Since(asked))
+	}
+}
+
+// interruptJob asks the broker for the job's world as it is now, to end the run with. If the broker can't give it,
+// the error is sent as an ErrorOccurred and interruptJob returns nil.
+func interruptJob(client *rpc.Client, job stubs.JobArgs, events chan<- Event, turnsComplete int) *stubs.GolAliveCells {
+	world := new(stubs.GolAliveCells)
+	if err := client.Call(stubs.InterruptEngine, job, world); err != nil {
+		fmt.Println("Failed to fetch the world: " + err.Error())
+		events <- ErrorOccurred{turnsComplete, err}
+		return nil
+	}
+	return world
 }
 
 func distributor(p Params, c distributorChannels) {
@@ -44,126 +199,206 @@ func distributor(p Params, c distributorChannels) {
 	fmt.Println(time.Now())
 	imageHeight := p.ImageHeight
 	imageWidth := p.ImageWidth
+	image := imageName(p)
 
-	c.ioCommand <- ioInput
-	c.ioFilename <- strconv.Itoa(p.ImageWidth) + "x" + strconv.Itoa(p.ImageHeight)
+	// An attached job already has its world on the broker.
+	var world util.BitGrid
+	if p.AttachJob == 0 {
+		// Patterns are in images/, with no extension meaning .rle, as are the images named for their size.
+		var path string
+		switch {
+		case p.Input != "":
+			path = p.Input
+		case p.Pattern != "":
+			path = filepath.Join("images", p.Pattern)
+			if filepath.Ext(p.Pattern) == "" {
+				path += ".rle"
+			}
+		default:
+			path = filepath.Join("images", image+".pgm")
+		}
+		c.ioCommand <- ioInput
+		c.ioFilename <- path
+		if err := <-c.ioError; err != nil {
+			quitWithError(c.events, err)
+			return
+		}
+		p.Rule = <-c.ioRule
 
-	world := make([][]uint8, imageHeight)
-	for i := 0; i < imageHeight; i++ {
-		world[i] = make([]uint8, imageWidth)
-		for j := range world[i] {
-			byte := <-c.ioInput
-			world[i][j] = byte
+		world = util.NewBitGrid(imageWidth, imageHeight)
+		for i := 0; i < imageHeight; i++ {
+			for j := 0; j < imageWidth; j++ {
+				byte := <-c.ioInput
+				world.Set(j, i, byte != 0)
+			}
 		}
 	}
 
-	ticker := time.NewTicker(2 * time.Second)
-	fmt.Println("Connecting to broker with IP: local")
-	client, _ := rpc.Dial("tcp", "127.0.0.1:8030")
+	if p.BrokerAddr == "" {
+		fmt.Println("No broker given, running in this process.")
+		runLocal(p, c, world)
+		return
+	}
+
+	fmt.Println("Connecting to broker with IP: " + p.BrokerAddr)
+	client, err := dialBroker(p.BrokerAddr)
+	if err != nil {
+		quitWithError(c.events, err)
+		return
+	}
 	//defer client.Close()
 
-	golArgs := stubs.GolArgs{Height: p.ImageHeight, Width: p.ImageWidth, Turns: p.Turns, World: world, Threads: p.Threads, Engines: p.Engines}
+	ticker := time.NewTicker(2 * time.Second)
+
 	response := new(stubs.GolAliveCells)
 
-	status := new(stubs.EngineStatus)
-	client.Call(stubs.CheckStatus, true, status)
-	if status.Working {
-		fmt.Println("Resuming connection to Engine, which is at turn: " + strconv.Itoa(status.Turn))
+	jobID := p.AttachJob
+	if jobID == 0 {
+		golArgs := stubs.GolArgs{Image: image, Height: p.ImageHeight, Width: p.ImageWidth, Turns: p.Turns, World: world, Threads: p.Threads, Engines: p.Engines, Rule: p.Rule, HashLife: p.HashLife}
+		if err = client.Call(stubs.SubmitJob, golArgs, &jobID); err != nil {
+			client.Close()
+			quitWithError(c.events, errors.New("broker refused job: "+err.Error()))
+			return
+		}
+		fmt.Println("Submitted job with ID: " + strconv.Itoa(jobID))
+	}
+	job := stubs.JobArgs{Job: jobID}
+
+	// shown is the world as the controller has sent it in CellFlipped events, which diffs from the broker are applied to.
+	shown := world
+	since := 0
+	if p.AttachJob != 0 {
+		shown = util.NewBitGrid(imageWidth, imageHeight)
+		since = -1
 	} else {
-		fmt.Println("Sending job to Engine.")
+		for _, cell := range world.AliveCells() {
+			c.events <- CellFlipped{0, cell}
+		}
 	}
 
-	rpcCall := client.Go(stubs.ProcessTurns, golArgs, response, nil)
-	fmt.Println("Recieved back from engine")
+	if p.AttachJob != 0 {
+		// Report where the job has got to straight away, rather than waiting for the first tick.
+		tickResponse := new(stubs.TickReport)
+		if err = client.Call(stubs.DoTick, job, tickResponse); err != nil {
+			client.Close()
+			quitWithError(c.events, err)
+			return
+		}
+		fmt.Println("Attached to job " + strconv.Itoa(jobID) + " at turn " + strconv.Itoa(tickResponse.Turns) + " of " + strconv.Itoa(p.Turns))
+		status := new(stubs.EngineStatus)
+		if client.Call(stubs.CheckStatus, job, status) == nil && status.Recovered {
+			fmt.Println("Job " + strconv.Itoa(jobID) + " was recovered from a checkpoint at turn " + strconv.Itoa(status.RecoveredTurn))
+		}
+		c.events <- AliveCellsCount{tickResponse.Turns, tickResponse.AliveCount}
+	}
+	rpcCall := client.Go(stubs.AwaitJob, job, response, nil)
+
+	diffs := make(chan stubs.WorldDiff)
+	stopWatching := make(chan struct{})
+	// Nothing comes on diffs unless something is taking the CellFlipped events they turn into.
+	if p.Watch || p.Record != "" {
+		go watchJob(client, jobID, since, diffs, stopWatching)
+	}
 
-	var returnedCells []util.Cell
 	var turnsComplete int
 	var workersPaused = false
+	// final is the world the run ends with, which is saved and sent in FinalTurnComplete however the run ends,
+	// unless it ends in an error.
+	var final *stubs.GolAliveCells
 
 	for {
 		select {
 		case <-ticker.C:
 			if workersPaused {
-				fmt.Printf("Ignoring Tick as workers paused")
+				// Renew the pause, which the broker would otherwise give up on in case this controller had gone away.
+				client.Call(stubs.PauseEngine, job, new(stubs.EngineStatus))
 			} else {
 				tickResponse := new(stubs.TickReport)
-				client.Call(stubs.DoTick, true, tickResponse)
+				client.Call(stubs.DoTick, job, tickResponse)
 				fmt.Println("Ticker Report:\nTurns Complete: " + strconv.Itoa(tickResponse.Turns) + "\nAlive Cells: " + strconv.Itoa(tickResponse.AliveCount))
+				turnsComplete = tickResponse.Turns
 				c.events <- AliveCellsCount{tickResponse.Turns, tickResponse.AliveCount}
 			}
+		case diff := <-diffs:
+			next := diff.Apply(shown)
+			for _, cell := range shown.Xor(next).AliveCells() {
+				c.events <- CellFlipped{diff.Turn, cell}
+			}
+			c.events <- TurnComplete{diff.Turn}
+			shown = next
+			turnsComplete = diff.Turn
 		case kp := <-c.keyPresses:
 			switch kp {
 			case 'p':
 				if workersPaused {
 					fmt.Printf("Instructing workers to resume... (continuing)")
 					resumedTurn := new(stubs.EngineStatus)
-					client.Call(stubs.ResumeEngine, true, resumedTurn)
+					client.Call(stubs.ResumeEngine, job, resumedTurn)
 					workersPaused = false
+					turnsComplete = resumedTurn.Turn
 					fmt.Println("Workers resumed at turn: " + strconv.Itoa(resumedTurn.Turn))
+					c.events <- StateChange{turnsComplete, Executing}
 				} else {
 					fmt.Println("Instructing workers to pause...")
 					pausedTurn := new(stubs.EngineStatus)
-					client.Call(stubs.PauseEngine, true, pausedTurn)
+					client.Call(stubs.PauseEngine, job, pausedTurn)
 					workersPaused = true
+					turnsComplete = pausedTurn.Turn
 					fmt.Println("Workers paused at turn: " + strconv.Itoa(pausedTurn.Turn))
+					c.events <- StateChange{turnsComplete, Paused}
 				}
 			case 'q':
 				if workersPaused {
 					fmt.Println("All execution currently paused. Please resume to quit the world.")
 				} else {
 					fmt.Println("Quitting, closing client side.")
-					c.ioCommand <- ioCheckIdle
-					<-c.ioIdle
-					close(c.events)
+					final = interruptJob(client, job, c.events, turnsComplete)
+					goto Exit
 				}
 			case 's':
-				if workersPaused {
-					fmt.Println("All excecution currently paused. Please resume to save the world.")
-				} else {
-					fmt.Println("Saving PGM...")
-					earlyResponse := new(stubs.GolAliveCells)
-					client.Call(stubs.InterruptEngine, true, earlyResponse)
-
-					returnedCells = earlyResponse.AliveCells
-					turnsComplete = earlyResponse.TurnsComplete
+				// The broker can still hand back the world of a paused job, at the turn it paused on.
+				fmt.Println("Saving PGM...")
+				earlyResponse := new(stubs.GolAliveCells)
+				client.Call(stubs.InterruptEngine, job, earlyResponse)
 
-					savePGM(p, c, returnedCells, turnsComplete)
-				}
+				turnsComplete = earlyResponse.TurnsComplete
+				saveWorld(p, c, earlyResponse.World, turnsComplete)
 			case 'k':
 				if workersPaused {
 					fmt.Println("All excecution currently paused. Please resume to shutdown Engines.")
 				} else {
-					fmt.Println("Saving PGM...")
-					earlyResponse := new(stubs.GolAliveCells)
-					client.Call(stubs.InterruptEngine, true, earlyResponse)
-
-					returnedCells = earlyResponse.AliveCells
-					turnsComplete = earlyResponse.TurnsComplete
-
-					savePGM(p, c, returnedCells, turnsComplete)
+					final = interruptJob(client, job, c.events, turnsComplete)
 
 					fmt.Println("Shutting down Engines...")
 					client.Call(stubs.KillEngine, true, true)
 					fmt.Println("Engine shut down.")
-					c.ioCommand <- ioCheckIdle
-					<-c.ioIdle
-					os.Exit(0)
+					goto Exit
 				}
 			}
 		case <-rpcCall.Done:
+			// Only now the result has arrived can the broker forget the job. A controller which quits
+			// before then leaves it for another to attach to.
+			client.Call(stubs.CollectJob, job, new(bool))
+			if rpcCall.Error != nil {
+				fmt.Println("Broker failed to process turns: " + rpcCall.Error.Error())
+				c.events <- ErrorOccurred{turnsComplete, rpcCall.Error}
+				goto Exit
+			}
 			fmt.Println("===== Engine has finished processing turns =====")
-			returnedCells = response.AliveCells
-			turnsComplete = response.TurnsComplete
-
-			c.events <- FinalTurnComplete{turnsComplete, returnedCells}
+			final = response
 			goto Exit
 		}
 	}
 
 Exit:
 	ticker.Stop()
-	fmt.Println("Saving PGM & shutting down controller.")
-	//savePGM(p, c, returnedCells, turnsComplete)
+	close(stopWatching)
+	if final != nil {
+		fmt.Println("Saving PGM & shutting down controller.")
+		turnsComplete = final.TurnsComplete
+		saveWorld(p, c, final.World, turnsComplete)
+		c.events <- FinalTurnComplete{turnsComplete, final.World.AliveCells()}
+	}
 
 	// Make sure that the Io has finished any output before exiting.
 	c.ioCommand <- ioCheckIdle
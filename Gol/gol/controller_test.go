@@ -0,0 +1,535 @@
+package gol
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol/netpbm"
+	"uk.ac.bris.cs/gameoflife/gol/pattern"
+	"uk.ac.bris.cs/gameoflife/gol/rle"
+	"uk.ac.bris.cs/gameoflife/gol/stubs"
+	"uk.ac.bris.cs/gameoflife/gol/testcluster"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// The io goroutine reads from images/ and writes to out/, both relative to the working directory.
+// The tests run in a temporary directory linking to the module's images, so nothing is written into the module.
+func TestMain(m *testing.M) {
+	images, err := filepath.Abs("../images")
+	util.Check(err)
+	dir, err := ioutil.TempDir("", "gol")
+	util.Check(err)
+	util.Check(os.Symlink(images, filepath.Join(dir, "images")))
+	util.Check(os.Chdir(dir))
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// runToEnd runs p with no key presses, returning the alive cells from the FinalTurnComplete event.
+func runToEnd(t *testing.T, p Params) []util.Cell {
+	events := make(chan Event)
+	go Run(p, events, nil)
+	var cells []util.Cell
+	final := false
+	for event := range events {
+		switch e := event.(type) {
+		case FinalTurnComplete:
+			cells = e.Alive
+			final = true
+		case ErrorOccurred:
+			t.Fatal(e.Err)
+		}
+	}
+	if !final {
+		t.Fatal("events closed without a FinalTurnComplete")
+	}
+	return cells
+}
+
+// assertCells checks the same cells are alive, both lists being ordered by row then column.
+func assertCells(t *testing.T, given, expected []util.Cell) {
+	if len(given) != len(expected) {
+		t.Fatalf("expected %v alive, got %v", expected, given)
+	}
+	for i := range given {
+		if given[i] != expected[i] {
+			t.Fatalf("expected %v alive, got %v", expected, given)
+		}
+	}
+}
+
+// waitForTurns reads events until an AliveCellsCount shows the broker has got past the first turn.
+func waitForTurns(t *testing.T, events <-chan Event) {
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if e, ok := event.(AliveCellsCount); ok && e.CompletedTurns > 0 {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no AliveCellsCount events received in 10 seconds")
+		}
+	}
+}
+
+// TestDistributed checks running on a cluster of engines, and with HashLife in this process, gives the same world
+// as running in this process.
+func TestDistributed(t *testing.T) {
+	cluster := testcluster.Start(t, 3)
+
+	p := Params{ImageWidth: 64, ImageHeight: 64, Turns: 100, Threads: 2}
+	expected := runToEnd(t, p)
+
+	p.HashLife = true
+	assertCells(t, runToEnd(t, p), expected)
+
+	p.HashLife = false
+	p.BrokerAddr = cluster.Addr()
+	assertCells(t, runToEnd(t, p), expected)
+}
+
+// TestPauseSaveKill pauses and resumes a long run, saves it, then shuts the whole cluster down with k,
+// which still ends the run with the world saved and a FinalTurnComplete.
+func TestPauseSaveKill(t *testing.T) {
+	cluster := testcluster.Start(t, 2)
+	p := Params{ImageWidth: 64, ImageHeight: 64, Turns: 100000000, Threads: 2, BrokerAddr: cluster.Addr()}
+
+	events := make(chan Event)
+	keyPresses := make(chan rune, 4)
+	go Run(p, events, keyPresses)
+	waitForTurns(t, events)
+
+	keyPresses <- 'p'
+	keyPresses <- 'p'
+	keyPresses <- 's'
+	keyPresses <- 'k'
+
+	quit, final := false, false
+	for event := range events {
+		switch e := event.(type) {
+		case StateChange:
+			quit = e.NewState == Quitting
+		case FinalTurnComplete:
+			final = true
+		case ErrorOccurred:
+			t.Fatal(e.Err)
+		}
+	}
+	if !quit {
+		t.Error("events closed without a StateChange to Quitting")
+	}
+	if !final {
+		t.Error("events closed without a FinalTurnComplete")
+	}
+
+	if saved, _ := filepath.Glob("out/64x64x*.pgm"); len(saved) == 0 {
+		t.Error("pressing s did not save the world")
+	}
+
+	select {
+	case <-cluster.Broker.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("k did not shut down the broker")
+	}
+	for i, e := range cluster.Engines {
+		select {
+		case <-e.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("k did not shut down engine %d", i)
+		}
+	}
+}
+
+// TestPattern starts from a glider in each pattern format, placed part way across the board, and checks it has
+// moved one cell diagonally after four turns, in both the final world and the patterns saved at the end.
+func TestPattern(t *testing.T) {
+	var expected []util.Cell
+	for _, cell := range []util.Cell{{X: 1, Y: 0}, {X: 2, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}, {X: 2, Y: 2}} {
+		expected = append(expected, util.Cell{X: cell.X + 3, Y: cell.Y + 4})
+	}
+	expected = util.BitGridFromCells(expected, 16, 16).AliveCells()
+
+	for _, name := range []string{"glider", "glider.rle", "glider.cells", "glider.lif"} {
+		t.Run(name, func(t *testing.T) {
+			p := Params{ImageWidth: 16, ImageHeight: 16, Turns: 4, Threads: 2, Pattern: name, PatternX: 2, PatternY: 3, SaveAs: PatternFormats}
+			given := runToEnd(t, p)
+			assertCells(t, given, expected)
+
+			for _, format := range PatternFormats {
+				file, err := os.Open(filepath.Join("out", "16x16x4."+format))
+				util.Check(err)
+				var saved pattern.Pattern
+				switch format {
+				case "rle":
+					saved, err = rle.Decode(file)
+				case "cells":
+					saved, err = pattern.DecodeCells(file)
+				case "lif":
+					saved, err = pattern.DecodeLife106(file)
+				}
+				file.Close()
+				util.Check(err)
+				world, err := saved.BitGrid(16, 16, 0, 0)
+				util.Check(err)
+				assertCells(t, world.AliveCells(), expected)
+			}
+		})
+	}
+}
+
+// TestInput checks an image of any name is read at the size its header gives, and the world is saved
+// in the directory and under the name asked for.
+func TestInput(t *testing.T) {
+	glider := []util.Cell{{X: 1, Y: 0}, {X: 2, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}, {X: 2, Y: 2}}
+	var expected []util.Cell
+	for _, cell := range glider {
+		expected = append(expected, util.Cell{X: cell.X + 1, Y: cell.Y + 1})
+	}
+	expected = util.BitGridFromCells(expected, 10, 7).AliveCells()
+
+	util.Check(os.MkdirAll("boards", 0755))
+	file, err := os.Create(filepath.Join("boards", "start.pgm"))
+	util.Check(err)
+	util.Check(netpbm.Encode(file, util.BitGridFromCells(glider, 10, 7)))
+	file.Close()
+
+	p, err := InputParams(Params{Turns: 4, Threads: 2, Input: filepath.Join("boards", "start.pgm"), OutDir: "saved", OutName: "{name}-{turns}-{width}x{height}"})
+	util.Check(err)
+	assertCells(t, runToEnd(t, p), expected)
+
+	file, err = os.Open(filepath.Join("saved", "start-4-10x7.pgm"))
+	util.Check(err)
+	saved, err := netpbm.Decode(file)
+	file.Close()
+	util.Check(err)
+	assertCells(t, saved.AliveCells(), expected)
+
+	// A name with dots in is still saved as a PGM image, with the extension added after them.
+	util.Check(os.Rename(filepath.Join("boards", "start.pgm"), filepath.Join("boards", "run.v2.pgm")))
+	p, err = InputParams(Params{Turns: 4, Threads: 2, Input: filepath.Join("boards", "run.v2.pgm"), OutDir: "saved", OutName: "{name}"})
+	util.Check(err)
+	assertCells(t, runToEnd(t, p), expected)
+
+	file, err = os.Open(filepath.Join("saved", "run.v2.pgm"))
+	util.Check(err)
+	saved, err = netpbm.Decode(file)
+	file.Close()
+	util.Check(err)
+	assertCells(t, saved.AliveCells(), expected)
+}
+
+// TestRecord checks a glider recorded every other turn gives a frame for each of those turns and the final one,
+// both as a GIF and as PNG frames, and that the world is saved as a PNG drawn at the scale and in the colours asked for.
+func TestRecord(t *testing.T) {
+	alive, dead := color.RGBA{R: 255, G: 136, A: 255}, color.RGBA{B: 64, A: 255}
+	for _, record := range []string{"glider.gif", "frames"} {
+		t.Run(record, func(t *testing.T) {
+			p := Params{ImageWidth: 16, ImageHeight: 16, Turns: 5, Threads: 2, Pattern: "glider", SaveAs: []string{"png"},
+				Scale: 3, AliveColour: alive, DeadColour: dead, Record: record, RecordEvery: 2}
+			final := runToEnd(t, p)
+
+			var frames []image.Image
+			if record == "frames" {
+				for i := 0; ; i++ {
+					file, err := os.Open(filepath.Join(record, fmt.Sprintf("%06d.png", i)))
+					if os.IsNotExist(err) {
+						break
+					}
+					util.Check(err)
+					frame, err := png.Decode(file)
+					file.Close()
+					util.Check(err)
+					frames = append(frames, frame)
+				}
+			} else {
+				file, err := os.Open(record)
+				util.Check(err)
+				animation, err := gif.DecodeAll(file)
+				file.Close()
+				util.Check(err)
+				for _, frame := range animation.Image {
+					frames = append(frames, frame)
+				}
+				if animation.Config.Width != 48 || animation.Config.Height != 48 {
+					t.Errorf("expected a 48x48 GIF, got %vx%v", animation.Config.Width, animation.Config.Height)
+				}
+			}
+			// Turns 0, 2, 4 and the final turn 5.
+			if len(frames) != 4 {
+				t.Fatalf("expected 4 frames, got %v", len(frames))
+			}
+
+			file, err := os.Open(filepath.Join("out", "16x16x5.png"))
+			util.Check(err)
+			saved, err := png.Decode(file)
+			file.Close()
+			util.Check(err)
+			if saved.Bounds().Dx() != 48 || saved.Bounds().Dy() != 48 {
+				t.Fatalf("expected a 48x48 PNG, got %v", saved.Bounds())
+			}
+			// Later GIF frames only cover what changed, so only the last PNG frame is a whole picture of the world.
+			pictures := []image.Image{saved}
+			if record == "frames" {
+				pictures = append(pictures, frames[len(frames)-1])
+			}
+			world := util.BitGridFromCells(final, 16, 16)
+			for _, picture := range pictures {
+				for y := 0; y < 48; y++ {
+					for x := 0; x < 48; x++ {
+						expected := dead
+						if world.Get(x/3, y/3) {
+							expected = alive
+						}
+						if color.RGBAModel.Convert(picture.At(x, y)) != expected {
+							t.Fatalf("expected pixel (%v, %v) to be %v, got %v", x, y, expected, picture.At(x, y))
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestBadImage checks an image which can't be read is reported as an error, rather than crashing the io goroutine.
+func TestBadImage(t *testing.T) {
+	for _, name := range []string{"64x64.pgm", "missing", "missing.cells", "glider.txt"} {
+		t.Run(name, func(t *testing.T) {
+			events := make(chan Event)
+			go Run(Params{ImageWidth: 16, ImageHeight: 16, Turns: 1, Threads: 1, Pattern: name}, events, nil)
+			failed := false
+			for event := range events {
+				if _, ok := event.(ErrorOccurred); ok {
+					failed = true
+				}
+			}
+			if !failed {
+				t.Errorf("expected an ErrorOccurred reading %v", name)
+			}
+		})
+	}
+}
+
+// TestKeyPressEvents pauses, saves, resumes and quits, checking each sends the events the GUI expects, in order,
+// with quitting saving the world and ending the run as finishing it would. Running on a broker and in this process
+// must send the same events.
+func TestKeyPressEvents(t *testing.T) {
+	cluster := testcluster.Start(t, 2)
+	for name, addr := range map[string]string{"broker": cluster.Addr(), "local": ""} {
+		t.Run(name, func(t *testing.T) {
+			p := Params{ImageWidth: 64, ImageHeight: 64, Turns: 100000000, Threads: 2, BrokerAddr: addr}
+
+			events := make(chan Event)
+			keyPresses := make(chan rune, 4)
+			go Run(p, events, keyPresses)
+			waitForTurns(t, events)
+
+			keyPresses <- 'p'
+			keyPresses <- 's'
+			keyPresses <- 'p'
+			keyPresses <- 'q'
+
+			var given []Event
+			for event := range events {
+				switch event.(type) {
+				case CellFlipped, TurnComplete, AliveCellsCount:
+				default:
+					given = append(given, event)
+				}
+			}
+			if len(given) != 6 {
+				t.Fatalf("expected 6 events, got %v", given)
+			}
+
+			paused, ok := given[0].(StateChange)
+			if !ok || paused.NewState != Paused {
+				t.Fatalf("expected a StateChange to Paused, got %#v", given[0])
+			}
+			turn := paused.CompletedTurns
+			filename := "64x64x" + strconv.Itoa(turn)
+			expected := []Event{
+				paused,
+				ImageOutputComplete{turn, filename},
+				StateChange{turn, Executing},
+			}
+			for i, e := range expected {
+				if given[i] != e {
+					t.Errorf("expected event %d to be %#v, got %#v", i, e, given[i])
+				}
+			}
+			quit, ok := given[5].(StateChange)
+			if !ok || quit.NewState != Quitting || quit.CompletedTurns < turn {
+				t.Fatalf("expected a StateChange to Quitting after turn %d, got %#v", turn, given[5])
+			}
+			if saved, ok := given[3].(ImageOutputComplete); !ok || saved.CompletedTurns != quit.CompletedTurns {
+				t.Errorf("expected the world to be saved at turn %d on quitting, got %#v", quit.CompletedTurns, given[3])
+			}
+			if final, ok := given[4].(FinalTurnComplete); !ok || final.CompletedTurns != quit.CompletedTurns {
+				t.Errorf("expected a FinalTurnComplete at turn %d on quitting, got %#v", quit.CompletedTurns, given[4])
+			}
+
+			if _, err := os.Stat(filepath.Join("out", filename+".pgm")); err != nil {
+				t.Errorf("expected the world to be saved to %v: %v", filename, err)
+			}
+		})
+	}
+}
+
+// TestQuitAndAttach quits a controller, finds its job still running on the broker, then attaches another
+// controller to it which saves the world without being told the image size.
+func TestQuitAndAttach(t *testing.T) {
+	cluster := testcluster.Start(t, 2)
+	p := Params{ImageWidth: 64, ImageHeight: 64, Turns: 100000000, Threads: 2, BrokerAddr: cluster.Addr()}
+
+	events := make(chan Event)
+	keyPresses := make(chan rune, 1)
+	go Run(p, events, keyPresses)
+	waitForTurns(t, events)
+
+	keyPresses <- 'q'
+	for range events {
+	}
+
+	jobs, err := ListJobs(cluster.Addr())
+	util.Check(err)
+	if len(jobs) != 1 {
+		t.Fatalf("expected the quit controller's job to be listed, got %+v", jobs)
+	}
+	job := jobs[0]
+	if !job.Working || job.Image != "64x64" || job.Width != 64 || job.Height != 64 || job.Turns != p.Turns {
+		t.Fatalf("job was not described correctly: %+v", job)
+	}
+
+	attached, err := AttachParams(Params{Threads: 2, BrokerAddr: cluster.Addr(), AttachJob: job.ID, OutDir: "attached"})
+	util.Check(err)
+	events = make(chan Event)
+	keyPresses = make(chan rune, 2)
+	go Run(attached, events, keyPresses)
+
+	// Attaching reports the job's progress straight away.
+	event := <-events
+	if e, ok := event.(AliveCellsCount); !ok || e.CompletedTurns < job.Turn {
+		t.Fatalf("expected the job's progress on attaching, got %v", event)
+	}
+
+	keyPresses <- 's'
+	keyPresses <- 'q'
+	var saved []string
+	for event := range events {
+		if e, ok := event.(ImageOutputComplete); ok {
+			saved = append(saved, e.Filename)
+		}
+	}
+	if len(saved) == 0 {
+		t.Fatal("pressing s on the attached controller did not save the world")
+	}
+	for _, filename := range saved {
+		if _, err := os.Stat(filepath.Join("attached", filename+".pgm")); err != nil {
+			t.Errorf("the attached controller's save is missing: %v", err)
+		}
+	}
+}
+
+// TestQuitThenCollect quits a controller, lets its job finish on the broker, then checks the job is still there
+// for another controller to attach to and collect the final world from.
+func TestQuitThenCollect(t *testing.T) {
+	cluster := testcluster.Start(t, 2)
+	p := Params{ImageWidth: 64, ImageHeight: 64, Turns: 10000, Threads: 2, BrokerAddr: cluster.Addr()}
+
+	events := make(chan Event)
+	keyPresses := make(chan rune, 1)
+	keyPresses <- 'q'
+	go Run(p, events, keyPresses)
+	for range events {
+	}
+
+	var jobs []stubs.JobInfo
+	for {
+		var err error
+		jobs, err = ListJobs(cluster.Addr())
+		util.Check(err)
+		if len(jobs) != 1 {
+			t.Fatalf("expected the quit controller's job to be kept once it finished, got %+v", jobs)
+		}
+		if !jobs[0].Working {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	events = make(chan Event)
+	collecting, err := AttachParams(Params{Threads: 2, BrokerAddr: cluster.Addr(), AttachJob: jobs[0].ID, OutDir: "collected"})
+	util.Check(err)
+	go Run(collecting, events, nil)
+	final := -1
+	for event := range events {
+		switch e := event.(type) {
+		case FinalTurnComplete:
+			final = e.CompletedTurns
+		case ErrorOccurred:
+			t.Fatal(e.Err)
+		}
+	}
+	if final != p.Turns {
+		t.Fatalf("expected the attached controller to collect the world after %d turns, got %d", p.Turns, final)
+	}
+
+	jobs, err = ListJobs(cluster.Addr())
+	util.Check(err)
+	if len(jobs) != 0 {
+		t.Errorf("expected the job to be forgotten once collected, got %+v", jobs)
+	}
+}
+
+// TestAttachUnknownJob checks attaching to a job the broker doesn't have is reported as an error.
+func TestAttachUnknownJob(t *testing.T) {
+	cluster := testcluster.Start(t, 1)
+	p := Params{BrokerAddr: cluster.Addr(), AttachJob: 42}
+	if _, err := AttachParams(p); err == nil {
+		t.Error("expected AttachParams to fail for a job that doesn't exist")
+	}
+
+	events := make(chan Event)
+	go Run(p, events, nil)
+
+	failed := false
+	for event := range events {
+		if _, ok := event.(ErrorOccurred); ok {
+			failed = true
+		}
+	}
+	if !failed {
+		t.Error("expected an ErrorOccurred attaching to a job that doesn't exist")
+	}
+}
+
+// TestUnknownSaveFormat checks a format to save the world in which isn't one of SaveFormats is reported as an
+// error before the run starts.
+func TestUnknownSaveFormat(t *testing.T) {
+	events := make(chan Event)
+	go Run(Params{Turns: 1, ImageWidth: 16, ImageHeight: 16, SaveAs: []string{"png", "bmp"}}, events, nil)
+
+	failed := false
+	for event := range events {
+		switch e := event.(type) {
+		case ErrorOccurred:
+			failed = true
+		case TurnComplete, FinalTurnComplete, ImageOutputComplete:
+			t.Fatalf("expected the run not to start, got %v", e)
+		}
+	}
+	if !failed {
+		t.Error("expected an ErrorOccurred saving as an unknown format")
+	}
+}
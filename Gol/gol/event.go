@@ -59,6 +59,13 @@ type TurnComplete struct { // implements Event
 	CompletedTurns int
 }
 
+// ErrorOccurred is an Event notifying the user that execution could not carry on, for example because the
+// broker could not be reached. It is followed by a StateChange to Quitting.
+type ErrorOccurred struct {
+	CompletedTurns int
+	Err            error
+}
+
 // FinalTurnComplete is an Event notifying the testing framework about the new world state after execution finished.
 // The data included with this Event is used directly by the tests.
 // SDL closes the window when this Event is sent.
@@ -122,6 +129,14 @@ func (event TurnComplete) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (event ErrorOccurred) String() string {
+	return fmt.Sprintf("Error: %v", event.Err)
+}
+
+func (event ErrorOccurred) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 func (event FinalTurnComplete) String() string {
 	return fmt.Sprintf("")
 }
@@ -1,16 +1,117 @@
 package gol
 
+import (
+	"errors"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
 // Params provides the details of how to run the Game of Life and which image to load.
+// Rule is the Life-like rule to apply, leaving it unset runs Conway's Game of Life.
+// HashLife has the broker jump many turns at a time with HashLife rather than using the engines.
+// BrokerAddr is the host:port of the broker to run on. Leaving it empty runs everything in this process.
+// AttachJob is the ID of a job already on the broker to take control of, rather than submitting the image as a new job.
+// The size of the image and the number of turns are then taken from the job by AttachParams, called before Run.
+// Watch has a controller running on a broker stream the job's world back as CellFlipped and TurnComplete events,
+// for a visualiser. It is implied by Record. Running in this process always sends them, as they cost nothing.
+// Pattern names a pattern in images/ to start from instead of an image, placed with its origin at
+// (PatternX, PatternY) on a board of ImageWidth by ImageHeight. Its extension gives its format: .rle, .cells or
+// Life 1.06 (.lif), with no extension meaning .rle. An RLE pattern's rule is used if Rule is unset.
+// Input is the path of an image or pattern to start from instead, of any name. The board is the size its header
+// gives, which InputParams sets before Run is called, and its extension gives its format: .pgm, .pbm, .rle, .cells
+// or .lif.
+// The world is saved in OutDir, named after OutName with {width}, {height}, {turns} and {name}, the name of the
+// image less its extension, filled in. They default to DefaultOutDir and DefaultOutName.
+// SaveAs lists the formats, from SaveFormats, to save the world in as well as a PGM image every time it is saved.
+// PNG images draw each cell as a square Scale pixels across in AliveColour or DeadColour, as do recordings.
+// Scale defaults to 1 and the zero colours to DefaultAliveColour and DefaultDeadColour.
+// Record is where to record the run to, as an animated GIF if it ends in .gif and otherwise as a directory of
+// numbered PNG frames. A frame is drawn every RecordEvery turns, or every turn if it is unset, and of the final turn.
+// On a broker, turns which pass while the controller is busy are coalesced, so a frame may be of a later turn.
 type Params struct {
 	Turns       int
 	Threads     int
 	ImageWidth  int
 	ImageHeight int
 	Engines     int
+	Rule        util.Rule
+	HashLife    bool
+	BrokerAddr  string
+	AttachJob   int
+	Watch       bool
+	Pattern     string
+	PatternX    int
+	PatternY    int
+	Input       string
+	OutDir      string
+	OutName     string
+	SaveAs      []string
+	Scale       int
+	AliveColour color.RGBA
+	DeadColour  color.RGBA
+	Record      string
+	RecordEvery int
+}
+
+// DefaultOutDir and DefaultOutName are where the world is saved unless Params say otherwise,
+// e.g. out/512x512x100.pgm.
+const (
+	DefaultOutDir  = "out"
+	DefaultOutName = "{width}x{height}x{turns}"
+)
+
+// PatternFormats are the pattern formats the world can be saved in, by file extension.
+var PatternFormats = []string{"rle", "cells", "lif"}
+
+// SaveFormats are all the formats the world can be saved in besides a PGM image, by file extension.
+var SaveFormats = append([]string{"png"}, PatternFormats...)
+
+// CheckSaveAs checks every format in saveAs is one of SaveFormats. Run checks Params.SaveAs with it, so an unknown
+// format fails before the run starts rather than when the world is first saved.
+func CheckSaveAs(saveAs []string) error {
+	for _, format := range saveAs {
+		known := false
+		for _, f := range SaveFormats {
+			known = known || format == f
+		}
+		if !known {
+			return errors.New("unknown format " + strconv.Quote(format) + ", expected one of " + strings.Join(SaveFormats, ", "))
+		}
+	}
+	return nil
 }
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
+// Params with Input or AttachJob set must already have been through InputParams or AttachParams.
 func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
+	if err := CheckSaveAs(p.SaveAs); err != nil {
+		quitWithError(events, err)
+		return
+	}
+	if p.OutDir == "" {
+		p.OutDir = DefaultOutDir
+	}
+	if p.OutName == "" {
+		p.OutName = DefaultOutName
+	}
+	if p.Scale < 1 {
+		p.Scale = 1
+	}
+	if p.AliveColour == (color.RGBA{}) {
+		p.AliveColour = DefaultAliveColour
+	}
+	if p.DeadColour == (color.RGBA{}) {
+		p.DeadColour = DefaultDeadColour
+	}
+	if p.RecordEvery < 1 {
+		p.RecordEvery = 1
+	}
+	if p.Record != "" {
+		events = record(p, events)
+	}
 
 	//	TODO: Put the missing channels in here.
 
@@ -20,6 +121,8 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 	filename := make(chan string)
 	output := make(chan uint8)
 	input := make(chan uint8)
+	rule := make(chan util.Rule)
+	ioError := make(chan error)
 
 	ioChannels := ioChannels{
 		command:  ioCommand,
@@ -27,6 +130,8 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 		filename: filename,
 		output:   output,
 		input:    input,
+		rule:     rule,
+		err:      ioError,
 	}
 	go startIo(p, ioChannels)
 
@@ -37,6 +142,8 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 		ioFilename: filename,
 		ioOutput:   output,
 		ioInput:    input,
+		ioRule:     rule,
+		ioError:    ioError,
 		keyPresses: keyPresses,
 	}
 
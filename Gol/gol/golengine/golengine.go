@@ -1,265 +1,196 @@
-package main
+// Package golengine is a GOL Engine, which registers with a broker and processes the strips of the world it is given.
+package golengine
 
 import (
-	"flag"
+	"errors"
 	"fmt"
 	"net"
 	"net/rpc"
-	"os"
 	"strconv"
 	"sync"
+	"uk.ac.bris.cs/gameoflife/gol/kernel"
 	"uk.ac.bris.cs/gameoflife/gol/stubs"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
-type GolEngine struct{}
-
-var world [][]byte
-var turn = 0
-var turns int
-var m sync.Mutex
-var width int
-var height int
-var working = false
-var offset int
-var eHeight int
-var singleWorker = false
-var listener net.Listener
-
-func isAlive(cell byte) bool {
-	if cell == 255 {
-		return true
-	}
-	return false
+// jobStrip is the band of rows an engine holds for one of the broker's jobs.
+type jobStrip struct {
+	m       sync.Mutex
+	offset  int
+	strip   util.BitGrid
+	threads int
+	rule    util.Rule
 }
 
-func getLiveNeighbours(width, height int, world [][]byte, a, b int) int {
-	var alive = 0
-	var widthLeft int
-	var widthRight int
-	var heightUp int
-	var heightDown int
-
-	//fmt.Println("Getting neighbours\nWidth " + strconv.Itoa(width) + "\nHeight: " + strconv.Itoa(height) + "\na: " + strconv.Itoa(a) + "\nb: " + strconv.Itoa(b))
-
-	if a == 0 {
-		widthLeft = width - 1
-	} else {
-		widthLeft = a - 1
-	}
-	if a == width-1 {
-		widthRight = 0
-	} else {
-		widthRight = a + 1
-	}
-
-	if b == 0 {
-		heightUp = height - 1
-	} else {
-		heightUp = b - 1
-	}
-
-	if b == height-1 {
-		heightDown = 0
-	} else {
-		heightDown = b + 1
-	}
-
-	if isAlive(world[widthLeft][b]) {
-		alive = alive + 1
-	}
-	if isAlive(world[widthRight][b]) {
-		alive = alive + 1
-	}
-	if isAlive(world[widthLeft][heightUp]) {
-		alive = alive + 1
-	}
-	if isAlive(world[a][heightUp]) {
-		alive = alive + 1
-	}
-	if isAlive(world[widthRight][heightUp]) {
-		alive = alive + 1
-	}
-	if isAlive(world[widthLeft][heightDown]) {
-		alive = alive + 1
-	}
-	if isAlive(world[a][heightDown]) {
-		alive = alive + 1
-	}
-	if isAlive(world[widthRight][heightDown]) {
-		alive = alive + 1
-	}
-	return alive
+// Engine serves the GolEngine RPCs to a broker it has registered with, keeping a strip for each of its jobs.
+type Engine struct {
+	m         sync.Mutex // guards strips
+	strips    map[int]*jobStrip
+	nextState kernel.Kernel
+
+	server   *stubs.Server
+	broker   *rpc.Client
+	address  string
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
-func calculateNextState(width, height int, world [][]byte) [][]byte {
-	newWorld := make([][]byte, width)
-	for i := range newWorld {
-		newWorld[i] = make([]byte, height)
-	}
-	for i := 0; i < height; i++ {
-		for j := 0; j < width; j++ {
-			neighbours := getLiveNeighbours(width, height, world, i, j)
-			if world[i][j] == 0xff && (neighbours < 2 || neighbours > 3) {
-				newWorld[i][j] = 0x0
-			} else if world[i][j] == 0x0 && neighbours == 3 {
-				newWorld[i][j] = 0xff
-			} else {
-				newWorld[i][j] = world[i][j]
-			}
-		}
-	}
-	return newWorld
+// New returns an engine which computes each turn with k, ready to Start.
+func New(k kernel.Kernel) *Engine {
+	return &Engine{strips: make(map[int]*jobStrip), nextState: k, done: make(chan struct{})}
 }
 
-func calculateAliveCells(width, height int, world [][]byte) []util.Cell {
-	newCell := []util.Cell{}
-	for x := 0; x < width; x++ {
-		for y := 0; y < height; y++ {
-			if world[x][y] == 0xff {
-				newCell = append(newCell, util.Cell{y, x})
-			}
-		}
-	}
-	return newCell
+// Start serves the engine on addr, returning once it is listening. Use port 0 for an ephemeral port.
+func (e *Engine) Start(addr string) (err error) {
+	e.server, err = stubs.Serve(addr, e)
+	return
 }
 
-func calculateAliveCount(world [][]byte) int {
-	count := 0
-	for x := range world {
-		for y := range world[x] {
-			if isAlive(world[x][y]) {
-				count++
-			}
-		}
-	}
-	return count
+// Addr returns the address the engine is listening on.
+func (e *Engine) Addr() string {
+	return e.server.Addr()
 }
 
-func (g *GolEngine) ProcessTurn(args stubs.EngineArgs, res *stubs.EngineResponse) (err error) {
-	m.Lock()
-	world = args.TotalWorld
-
-	fmt.Println("Engine Processing Turn between Y: " + strconv.Itoa(args.Offset) + " and Y: " + strconv.Itoa(args.Offset+args.Height))
-	fmt.Println("Total world given has " + strconv.Itoa(calculateAliveCount(world)) + " alive cells...")
+// Register tells the broker at brokerAddr this engine is ready for work. The broker dials back to ip on the
+// port the engine is listening on, so engines started on port 0 can still be reached.
+func (e *Engine) Register(brokerAddr, ip string, capacity int) error {
+	broker, err := rpc.Dial("tcp", brokerAddr)
+	if err != nil {
+		return err
+	}
+	address := net.JoinHostPort(ip, strconv.Itoa(e.server.Port()))
+	var id int
+	err = broker.Call(stubs.RegisterEngine, stubs.EngineRegistration{Address: address, Capacity: capacity}, &id)
+	if err != nil {
+		broker.Close()
+		return err
+	}
+	fmt.Println("Registered with broker as Engine with ID: " + strconv.Itoa(id))
+	e.broker = broker
+	e.address = address
+	return nil
+}
 
-	aliveCells := []util.Cell{}
-	for i := 0; i < args.TWidth; i++ {
-		for j := args.Offset; j < args.Offset+args.Height; j++ {
-			neighbours := getLiveNeighbours(args.TWidth, args.THeight, world, i, j)
-			if world[i][j] == 0xff && (neighbours < 2 || neighbours > 3) {
-				// cell dies, don't add to alive cells (duh)
-			} else if world[i][j] == 0x0 && neighbours == 3 {
-				aliveCells = append(aliveCells, util.Cell{X: j, Y: i})
-			} else {
-				if isAlive(world[i][j]) {
-					aliveCells = append(aliveCells, util.Cell{X: j, Y: i})
-				}
-			}
-		}
+// Deregister removes this engine from the broker it registered with, so it is no longer given any work.
+func (e *Engine) Deregister() error {
+	if e.broker == nil {
+		return nil
 	}
-
-	res.AliveCells = aliveCells
-	m.Unlock()
-	return
+	err := e.broker.Call(stubs.DeregisterEngine, stubs.EngineRegistration{Address: e.address}, new(bool))
+	e.broker.Close()
+	e.broker = nil
+	return err
 }
 
-func (g *GolEngine) ProcessTurns(args stubs.GolArgs, res *stubs.GolAliveCells) (err error) {
-	if !working { // If ProcessTurns is called again, it's a new client connection, continue working on current job
-		turns = args.Turns
-		turn = 0
-		world = args.World
-		width = args.Width
-		height = args.Height
-		working = true
-
-		n := 0
-		for n < 10 {
-			n++
-			fmt.Println("========== STARTING PROCESSING " + strconv.Itoa(turn) + "/" + strconv.Itoa(args.Turns) + "TURNS ==========")
-		}
-
-	} else {
-		fmt.Println("Client called ProcessTurns while still working, continuing work")
-	}
+// Stop closes every connection to the engine without deregistering it, so to a broker it looks as if the engine
+// has died. It is safe to call more than once.
+func (e *Engine) Stop() {
+	e.stopOnce.Do(func() {
+		e.server.Close()
+		close(e.done)
+	})
+}
 
-	for turn < turns {
-		m.Lock()
-		if turn%50 == 0 {
-			fmt.Println("Engine Processing Turn: " + strconv.Itoa(turn))
-		}
-		world = calculateNextState(width, height, world)
-		turn++
-		m.Unlock()
-	}
+// Done is closed once the engine has stopped, whether by Stop or by a call to KillEngine.
+func (e *Engine) Done() <-chan struct{} {
+	return e.done
+}
 
-	res.TurnsComplete = turns
-	res.AliveCells = calculateAliveCells(width, height, world)
-	working = false
-	n := 0
-	for n < 10 {
-		n++
-		fmt.Println("========== FINISHED PROCESSING ALL " + strconv.Itoa(turn) + " TURNS ==========")
+// jobStrip returns the strip this engine holds for a job.
+func (e *Engine) jobStrip(id int) (*jobStrip, error) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	js, ok := e.strips[id]
+	if !ok {
+		return nil, errors.New("no strip loaded for job " + strconv.Itoa(id))
 	}
-	return
+	return js, nil
 }
 
-func (g *GolEngine) DoTick(_ bool, res *stubs.TickReport) (err error) {
-	fmt.Println("Got do tick request...")
-	m.Lock()
-	res.AliveCount = calculateAliveCount(world)
-	res.Turns = turn
-	m.Unlock()
+// LoadStrip gives this engine the band of rows it is responsible for in a job, which it keeps between turns.
+func (e *Engine) LoadStrip(args stubs.StripArgs, _ *bool) (err error) {
+	js := &jobStrip{strip: args.Strip, offset: args.Offset, threads: args.Threads, rule: args.Rule.OrDefault()}
+	e.m.Lock()
+	e.strips[args.Job] = js
+	e.m.Unlock()
+	fmt.Println("Engine loaded strip for job " + strconv.Itoa(args.Job) + " between Y: " + strconv.Itoa(js.offset) + " and Y: " + strconv.Itoa(js.offset+js.strip.Height) + " to process " + js.rule.String() + " with " + strconv.Itoa(js.threads) + " threads")
 	return
 }
 
-func (g *GolEngine) PauseEngine(_ bool, res *stubs.EngineStatus) (err error) {
-	m.Lock()
-	fmt.Println("pausing engine on turn " + strconv.Itoa(turn) + "...")
-	res.Turn = turn
-	res.Working = working
+// GetStrip returns the current state of this engine's strip for a job.
+func (e *Engine) GetStrip(args stubs.JobArgs, res *stubs.StripArgs) (err error) {
+	js, err := e.jobStrip(args.Job)
+	if err != nil {
+		return
+	}
+	js.m.Lock()
+	res.Job = args.Job
+	res.Offset = js.offset
+	res.Strip = js.strip
+	js.m.Unlock()
 	return
 }
 
-func (g *GolEngine) ResumeEngine(_ bool, res *stubs.EngineStatus) (err error) {
-	fmt.Println("resuming engine from turn " + strconv.Itoa(turn))
-	res.Turn = turn
-	res.Working = working
-	m.Unlock()
+// DropStrip forgets this engine's strip for a job which has finished.
+func (e *Engine) DropStrip(args stubs.JobArgs, _ *bool) (err error) {
+	e.m.Lock()
+	delete(e.strips, args.Job)
+	e.m.Unlock()
 	return
 }
 
-func (g *GolEngine) InterruptEngine(_ bool, res *stubs.GolAliveCells) (err error) {
-	m.Lock()
-	fmt.Println("Interrupt triggered, returning current work to controller.")
-
-	res.TurnsComplete = turn
-	res.AliveCells = calculateAliveCells(width, height, world)
-	m.Unlock()
-	return
+// stripWorker computes rows startY up to endY of the next strip. rows holds the current strip with a halo row
+// above and below it, so row y of the strip is row y+1 of rows.
+func (e *Engine) stripWorker(rows, nextStrip util.BitGrid, rule util.Rule, startY, endY int, done chan<- bool) {
+	e.nextState(rows, nextStrip, rule, startY, endY)
+	done <- true
 }
 
-func (g *GolEngine) CheckStatus(_ bool, res *stubs.EngineStatus) (err error) {
-	m.Lock()
-	res.Turn = turn
-	res.Working = working
-	m.Unlock()
+// ProcessTurn advances a job's strip by one turn, using the halo rows from the neighbouring strips.
+// The strip is split between threads worker goroutines.
+// Only the new first and last rows are sent back, as those are all the neighbouring strips need.
+func (e *Engine) ProcessTurn(args stubs.EngineArgs, res *stubs.EngineResponse) (err error) {
+	js, err := e.jobStrip(args.Job)
+	if err != nil {
+		return
+	}
+	js.m.Lock()
+	defer js.m.Unlock()
+	strip := js.strip
+	rows := kernel.WithHalo(strip, args.Top, args.Bottom)
+
+	workers := js.threads
+	if workers > strip.Height {
+		workers = strip.Height
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Each row starts on a new word, so workers writing different rows never touch the same word.
+	nextStrip := util.NewBitGrid(strip.Width, strip.Height)
+	done := make(chan bool)
+	workerHeight := strip.Height / workers
+	for i := 0; i < workers; i++ {
+		endY := workerHeight * (i + 1)
+		if i == workers-1 {
+			endY = strip.Height
+		}
+		go e.stripWorker(rows, nextStrip, js.rule, workerHeight*i, endY, done)
+	}
+	for i := 0; i < workers; i++ {
+		<-done
+	}
+	js.strip = nextStrip
+
+	res.Top = nextStrip.Row(0)
+	res.Bottom = nextStrip.Row(nextStrip.Height - 1)
+	res.AliveCount = nextStrip.AliveCount()
 	return
 }
 
-func (g *GolEngine) KillEngine(_ bool, _ *bool) (err error) {
+func (e *Engine) KillEngine(_ bool, _ *bool) (err error) {
 	fmt.Println("Shutting down...")
-	os.Exit(0)
+	go e.Stop()
 	return
 }
-
-func main() {
-	pAddr := flag.String("port", "8031", "Port to listen on")
-	flag.Parse()
-	fmt.Println("Super Cool Distributed Game of Life Engine is running on port: " + *pAddr)
-
-	rpc.Register(&GolEngine{})
-	listener, _ := net.Listen("tcp", ":"+*pAddr)
-	defer listener.Close()
-	rpc.Accept(listener)
-}
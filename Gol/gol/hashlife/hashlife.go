@@ -0,0 +1,245 @@
+package hashlife
+
+import "uk.ac.bris.cs/gameoflife/util"
+
+// maxNodes is how many distinct nodes the universe may hold before its tables are thrown away between jumps.
+const maxNodes = 1 << 22
+
+// node is a square of 2^level by 2^level cells, built from its four quadrants.
+// Nodes are hash-consed: any two nodes with the same contents are the same pointer.
+type node struct {
+	nw, ne, sw, se *node
+	level          uint
+	population     int
+}
+
+type resultKey struct {
+	n *node
+	j uint
+}
+
+// universe holds every node built so far, and the memoised results of advancing them.
+type universe struct {
+	rule    util.Rule
+	leaves  [2]*node
+	nodes   map[[4]*node]*node
+	empty   []*node
+	results map[resultKey]*node
+}
+
+func newUniverse(rule util.Rule) *universe {
+	dead := &node{}
+	alive := &node{population: 1}
+	return &universe{
+		rule:    rule,
+		leaves:  [2]*node{dead, alive},
+		nodes:   make(map[[4]*node]*node),
+		empty:   []*node{dead},
+		results: make(map[resultKey]*node),
+	}
+}
+
+// join returns the node made of the four given quadrants.
+func (u *universe) join(nw, ne, sw, se *node) *node {
+	key := [4]*node{nw, ne, sw, se}
+	if n, ok := u.nodes[key]; ok {
+		return n
+	}
+	n := &node{nw, ne, sw, se, nw.level + 1, nw.population + ne.population + sw.population + se.population}
+	u.nodes[key] = n
+	return n
+}
+
+// emptyNode returns the node of the given level with no alive cells.
+func (u *universe) emptyNode(level uint) *node {
+	for uint(len(u.empty)) <= level {
+		e := u.empty[len(u.empty)-1]
+		u.empty = append(u.empty, u.join(e, e, e, e))
+	}
+	return u.empty[level]
+}
+
+// centre returns the middle half of n, without advancing it.
+func (u *universe) centre(n *node) *node {
+	return u.join(n.nw.se, n.ne.sw, n.sw.ne, n.se.nw)
+}
+
+// advanceBase advances the middle 2x2 cells of a 4x4 node by one generation.
+func (u *universe) advanceBase(n *node) *node {
+	var cells [4][4]bool
+	for i, quadrant := range [4]*node{n.nw, n.ne, n.sw, n.se} {
+		for j, leaf := range [4]*node{quadrant.nw, quadrant.ne, quadrant.sw, quadrant.se} {
+			cells[i/2*2+j/2][i%2*2+j%2] = leaf.population == 1
+		}
+	}
+
+	next := func(x, y int) *node {
+		neighbours := 0
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if (dx != 0 || dy != 0) && cells[y+dy][x+dx] {
+					neighbours++
+				}
+			}
+		}
+		if u.rule.Next(cells[y][x], neighbours) {
+			return u.leaves[1]
+		}
+		return u.leaves[0]
+	}
+	return u.join(next(1, 1), next(2, 1), next(1, 2), next(2, 2))
+}
+
+// advance returns the middle half of n, 2^j generations on. j can be at most n.level-2.
+func (u *universe) advance(n *node, j uint) *node {
+	key := resultKey{n, j}
+	if result, ok := u.results[key]; ok {
+		return result
+	}
+
+	var result *node
+	if n.level == 2 {
+		result = u.advanceBase(n)
+	} else {
+		// The nine overlapping squares of half the size that tile n.
+		n00 := n.nw
+		n01 := u.join(n.nw.ne, n.ne.nw, n.nw.se, n.ne.sw)
+		n02 := n.ne
+		n10 := u.join(n.nw.sw, n.nw.se, n.sw.nw, n.sw.ne)
+		n11 := u.centre(n)
+		n12 := u.join(n.ne.sw, n.ne.se, n.se.nw, n.se.ne)
+		n20 := n.sw
+		n21 := u.join(n.sw.ne, n.se.nw, n.sw.se, n.se.sw)
+		n22 := n.se
+
+		// At full speed both halves of the jump are taken recursively, otherwise the first half takes no time.
+		first := u.centre
+		if j == n.level-2 {
+			half := j - 1
+			first = func(n *node) *node { return u.advance(n, half) }
+			j = half
+		}
+		r00, r01, r02 := first(n00), first(n01), first(n02)
+		r10, r11, r12 := first(n10), first(n11), first(n12)
+		r20, r21, r22 := first(n20), first(n21), first(n22)
+
+		result = u.join(
+			u.advance(u.join(r00, r01, r10, r11), j),
+			u.advance(u.join(r01, r02, r11, r12), j),
+			u.advance(u.join(r10, r11, r20, r21), j),
+			u.advance(u.join(r11, r12, r21, r22), j),
+		)
+	}
+	u.results[key] = result
+	return result
+}
+
+// build returns the node of the given level whose top left cell is (x0, y0), containing cells.
+func (u *universe) build(level uint, x0, y0 int, cells []util.Cell) *node {
+	if len(cells) == 0 {
+		return u.emptyNode(level)
+	}
+	if level == 0 {
+		return u.leaves[1]
+	}
+
+	half := 1 << (level - 1)
+	var quadrants [4][]util.Cell
+	for _, cell := range cells {
+		i := 0
+		if cell.X >= x0+half {
+			i++
+		}
+		if cell.Y >= y0+half {
+			i += 2
+		}
+		quadrants[i] = append(quadrants[i], cell)
+	}
+	return u.join(
+		u.build(level-1, x0, y0, quadrants[0]),
+		u.build(level-1, x0+half, y0, quadrants[1]),
+		u.build(level-1, x0, y0+half, quadrants[2]),
+		u.build(level-1, x0+half, y0+half, quadrants[3]),
+	)
+}
+
+// collect sets the alive cells of n, whose top left cell is (x0, y0), which fall inside world.
+func (u *universe) collect(n *node, x0, y0 int, world util.BitGrid) {
+	if n.population == 0 || x0 >= world.Width || y0 >= world.Height {
+		return
+	}
+	if n.level == 0 {
+		world.Set(x0, y0, true)
+		return
+	}
+	half := 1 << (n.level - 1)
+	u.collect(n.nw, x0, y0, world)
+	u.collect(n.ne, x0+half, y0, world)
+	u.collect(n.sw, x0, y0+half, world)
+	u.collect(n.se, x0+half, y0+half, world)
+}
+
+// Life runs the Game of Life on a closed domain with HashLife, jumping many generations at a time.
+// The world is tiled across a square node big enough that the middle of the node, once advanced,
+// covers the whole world. Tiling makes the node wrap around just like the closed domain.
+type Life struct {
+	world    util.BitGrid
+	rule     util.Rule
+	level    uint
+	universe *universe
+}
+
+// New returns a Life starting from world, using rule.
+func New(world util.BitGrid, rule util.Rule) *Life {
+	size := world.Width
+	if world.Height > size {
+		size = world.Height
+	}
+	// The middle half of the node, 2^(level-1) wide, must cover the world.
+	level := uint(3)
+	for 1<<(level-1) < size {
+		level++
+	}
+	return &Life{world: world, rule: rule, level: level, universe: newUniverse(rule)}
+}
+
+// World returns the current state of the world.
+func (l *Life) World() util.BitGrid {
+	return l.world
+}
+
+// Advance jumps forward by the largest power of two generations which is at most maxTurns,
+// limited by the size of the world. It returns the number of generations advanced.
+func (l *Life) Advance(maxTurns int) int {
+	if maxTurns < 1 {
+		return 0
+	}
+	j := uint(0)
+	for j < l.level-2 && 1<<(j+1) <= maxTurns {
+		j++
+	}
+
+	if len(l.universe.nodes) > maxNodes {
+		l.universe = newUniverse(l.rule)
+	}
+
+	// Tile the world across [-q, 3q), shifted by q so the node starts at (0, 0).
+	// After advancing, the middle of the node covers [0, 2q) of the world.
+	q := 1 << (l.level - 2)
+	var tiled []util.Cell
+	for _, cell := range l.world.AliveCells() {
+		for x := cell.X - (cell.X+q)/l.world.Width*l.world.Width; x < 3*q; x += l.world.Width {
+			for y := cell.Y - (cell.Y+q)/l.world.Height*l.world.Height; y < 3*q; y += l.world.Height {
+				tiled = append(tiled, util.Cell{X: x + q, Y: y + q})
+			}
+		}
+	}
+
+	root := l.universe.build(l.level, 0, 0, tiled)
+	result := l.universe.advance(root, j)
+
+	next := util.NewBitGrid(l.world.Width, l.world.Height)
+	l.universe.collect(result, 0, 0, next)
+	l.world = next
+	return 1 << j
+}
@@ -0,0 +1,69 @@
+package hashlife
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol/kernel"
+	"uk.ac.bris.cs/gameoflife/gol/testworld"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// run advances l until it has completed turns, as the broker does.
+func run(l *Life, turns int) {
+	for turn := 0; turn < turns; {
+		turn += l.Advance(turns - turn)
+	}
+}
+
+// TestHashLife checks the 16x16, 64x64 and 512x512 images after 0, 1 and 100 turns.
+func TestHashLife(t *testing.T) {
+	for _, size := range []int{16, 64, 512} {
+		for _, turns := range []int{0, 1, 100} {
+			t.Run(fmt.Sprintf("%dx%dx%d", size, size, turns), func(t *testing.T) {
+				l := New(testworld.Read(t, fmt.Sprintf("../../images/%dx%d.pgm", size, size)), util.Conway)
+				run(l, turns)
+				testworld.AssertEqual(t, l.World(), testworld.Read(t, fmt.Sprintf("../../check/images/%dx%dx%d.pgm", size, size, turns)))
+			})
+		}
+	}
+}
+
+// TestHashLifeMatchesKernel runs random worlds which aren't square or a power of two under several rules.
+func TestHashLifeMatchesKernel(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, rulestring := range []string{"B3/S23", "B36/S23", "B2/S", "B0123478/S01234678"} {
+		rule, err := util.ParseRule(rulestring)
+		util.Check(err)
+		for _, size := range [][2]int{{5, 3}, {17, 40}, {100, 37}} {
+			t.Run(fmt.Sprintf("%s-%dx%d", rulestring, size[0], size[1]), func(t *testing.T) {
+				world := util.NewBitGrid(size[0], size[1])
+				for y := 0; y < world.Height; y++ {
+					for x := 0; x < world.Width; x++ {
+						world.Set(x, y, random.Intn(3) == 0)
+					}
+				}
+				l := New(world, rule)
+				run(l, 77)
+				for turn := 0; turn < 77; turn++ {
+					world = kernel.Step(kernel.Bitwise, world, rule)
+				}
+				testworld.AssertEqual(t, l.World(), world)
+			})
+		}
+	}
+}
+
+// TestHashLifeMillions moves a glider a million turns, which brings it 250000 cells diagonally.
+func TestHashLifeMillions(t *testing.T) {
+	glider := []util.Cell{{X: 1, Y: 0}, {X: 2, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}, {X: 2, Y: 2}}
+	l := New(util.BitGridFromCells(glider, 64, 64), util.Conway)
+	run(l, 1000000)
+
+	var moved []util.Cell
+	for _, cell := range glider {
+		moved = append(moved, util.Cell{X: (cell.X + 250000) % 64, Y: (cell.Y + 250000) % 64})
+	}
+	testworld.AssertEqual(t, l.World(), util.BitGridFromCells(moved, 64, 64))
+}
@@ -1,11 +1,15 @@
 package gol
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"uk.ac.bris.cs/gameoflife/gol/netpbm"
+	"uk.ac.bris.cs/gameoflife/gol/pattern"
+	"uk.ac.bris.cs/gameoflife/gol/rle"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
@@ -16,6 +20,8 @@ type ioChannels struct {
 	filename <-chan string
 	output   <-chan uint8
 	input    chan<- uint8
+	rule     chan<- util.Rule
+	err      chan<- error
 }
 
 // ioState is the internal ioState of the io goroutine.
@@ -39,13 +45,11 @@ const (
 )
 
 // writePgmImage receives an array of bytes and writes it to a pgm file.
-func (io *ioState) writePgmImage() {
-	_ = os.Mkdir("out", os.ModePerm)
+func (io *ioState) writePgmImage(filename string) {
+	path := filepath.Join(io.params.OutDir, filename+".pgm")
+	_ = os.MkdirAll(filepath.Dir(path), os.ModePerm)
 
-	// Request a filename from the distributor.
-	filename := <-io.channels.filename
-
-	file, ioError := os.Create("out/" + filename + ".pgm")
+	file, ioError := os.Create(path)
 	util.Check(ioError)
 	defer file.Close()
 
@@ -86,43 +90,178 @@ func (io *ioState) writePgmImage() {
 	fmt.Println("File", filename, "output done!")
 }
 
-// readPgmImage opens a pgm file and sends its data as an array of bytes.
-func (io *ioState) readPgmImage() {
+// isImage reports whether path names a pbm or pgm image, rather than a pattern.
+func isImage(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".pgm" || ext == ".pbm"
+}
 
-	// Request a filename from the distributor.
-	filename := <-io.channels.filename
+// decodePattern decodes an .rle, .cells or Life 1.06 (.lif) pattern, as the file's extension names.
+func decodePattern(file *os.File) (pattern.Pattern, error) {
+	switch filepath.Ext(file.Name()) {
+	case ".rle":
+		return rle.Decode(file)
+	case ".cells":
+		return pattern.DecodeCells(file)
+	case ".lif", ".life":
+		return pattern.DecodeLife106(file)
+	}
+	return pattern.Pattern{}, errors.New("unknown image format " + file.Name())
+}
 
-	data, ioError := ioutil.ReadFile("images/" + filename + ".pgm")
-	util.Check(ioError)
+// InputParams sets the size of the board to the size of the image or pattern at p.Input, as given in its header.
+// A pattern without a header, in the .cells or Life 1.06 formats, is as large as its cells reach.
+// It is called before Run, which uses the params as they are.
+func InputParams(p Params) (Params, error) {
+	file, err := os.Open(p.Input)
+	if err != nil {
+		return p, err
+	}
+	defer file.Close()
 
-	fields := strings.Fields(string(data))
+	if isImage(p.Input) {
+		p.ImageWidth, p.ImageHeight, err = netpbm.DecodeConfig(file)
+	} else {
+		var decoded pattern.Pattern
+		decoded, err = decodePattern(file)
+		p.ImageWidth, p.ImageHeight = decoded.Width, decoded.Height
+		if err == nil && (decoded.Width < 1 || decoded.Height < 1) {
+			err = errors.New("pattern is empty")
+		}
+	}
+	if err != nil {
+		return p, fmt.Errorf("%v: %v", p.Input, err)
+	}
+	return p, nil
+}
 
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
+// decodeImage opens the image or pattern at path, returning the board and the rule the file gives, if any.
+// An image must be the size given in the params, and a pattern is placed on the board at the offset they give.
+func (io *ioState) decodeImage(path string) (util.BitGrid, util.Rule, error) {
+	file, ioError := os.Open(path)
+	if ioError != nil {
+		return util.BitGrid{}, util.Rule{}, ioError
+	}
+	defer file.Close()
+
+	if isImage(path) {
+		world, ioError := netpbm.Decode(file)
+		if ioError != nil {
+			return util.BitGrid{}, util.Rule{}, fmt.Errorf("%v: %v", path, ioError)
+		}
+		if world.Width != io.params.ImageWidth || world.Height != io.params.ImageHeight {
+			return util.BitGrid{}, util.Rule{}, fmt.Errorf("%v is %vx%v, expected %vx%v", path, world.Width, world.Height, io.params.ImageWidth, io.params.ImageHeight)
+		}
+		return world, util.Rule{}, nil
 	}
 
-	width, _ := strconv.Atoi(fields[1])
-	if width != io.params.ImageWidth {
-		panic("Incorrect width")
+	decoded, ioError := decodePattern(file)
+	if ioError != nil {
+		return util.BitGrid{}, util.Rule{}, fmt.Errorf("%v: %v", path, ioError)
 	}
+	world, ioError := io.placePattern(decoded)
+	return world, decoded.Rule, ioError
+}
 
-	height, _ := strconv.Atoi(fields[2])
-	if height != io.params.ImageHeight {
-		panic("Incorrect height")
+// readImage requests a path from the distributor and reads the file in the format its extension names:
+// a .pgm or .pbm image, or an .rle, .cells or Life 1.06 (.lif) pattern.
+// It sends the error reading the file, nil if there wasn't one, then the rule to run with, which is the params'
+// rule unless only an RLE pattern gives one, and then the board as an array of bytes.
+func (io *ioState) readImage() {
+	path := <-io.channels.filename
+	world, rule, ioError := io.decodeImage(path)
+	io.channels.err <- ioError
+	if ioError != nil {
+		return
 	}
 
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
+	if io.params.Rule == (util.Rule{}) {
+		io.params.Rule = rule
 	}
+	io.channels.rule <- io.params.Rule
+	io.sendWorld(world)
 
-	image := []byte(fields[4])
+	fmt.Println("File", path, "input done!")
+}
 
-	for _, b := range image {
-		io.channels.input <- b
+// writeImage requests a filename from the distributor and writes the array of bytes it then sends in the format
+// the filename's extension names: a pgm or png image, or an .rle, .cells or Life 1.06 (.lif) pattern.
+func (io *ioState) writeImage() {
+	filename := <-io.channels.filename
+	switch filepath.Ext(filename) {
+	case ".pgm":
+		io.writePgmImage(strings.TrimSuffix(filename, ".pgm"))
+	case ".png":
+		io.writePngImage(filename)
+	default:
+		io.writePatternImage(filename)
 	}
+}
 
-	fmt.Println("File", filename, "input done!")
+// sendWorld sends world as an array of bytes, one per cell and row by row.
+func (io *ioState) sendWorld(world util.BitGrid) {
+	for y := 0; y < io.params.ImageHeight; y++ {
+		for x := 0; x < io.params.ImageWidth; x++ {
+			var b uint8
+			if world.Get(x, y) {
+				b = 255
+			}
+			io.channels.input <- b
+		}
+	}
+}
+
+// placePattern places a decoded pattern on the board at the offset given in the params.
+func (io *ioState) placePattern(decoded pattern.Pattern) (util.BitGrid, error) {
+	return decoded.BitGrid(io.params.ImageWidth, io.params.ImageHeight, io.params.PatternX, io.params.PatternY)
+}
+
+// receiveWorld receives a world as an array of bytes, one per cell and row by row.
+func (io *ioState) receiveWorld() util.BitGrid {
+	world := util.NewBitGrid(io.params.ImageWidth, io.params.ImageHeight)
+	for y := 0; y < io.params.ImageHeight; y++ {
+		for x := 0; x < io.params.ImageWidth; x++ {
+			world.Set(x, y, <-io.channels.output != 0)
+		}
+	}
+	return world
+}
+
+// writePngImage receives an array of bytes like writePgmImage and draws it as a png image, with the cell size
+// and colours given in the params.
+func (io *ioState) writePngImage(filename string) {
+	path := filepath.Join(io.params.OutDir, filename)
+	_ = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+
+	util.Check(writePNG(path, renderWorld(io.params, io.receiveWorld())))
+
+	fmt.Println("File", filename, "output done!")
+}
+
+// writePatternImage receives an array of bytes like writePgmImage and writes the alive cells as an .rle,
+// .cells or Life 1.06 (.lif) pattern, as the filename's extension names.
+func (io *ioState) writePatternImage(filename string) {
+	path := filepath.Join(io.params.OutDir, filename)
+	_ = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+
+	world := io.receiveWorld()
+	file, ioError := os.Create(path)
+	util.Check(ioError)
+	defer file.Close()
+	switch filepath.Ext(filename) {
+	case ".rle":
+		ioError = rle.Encode(file, world, io.params.Rule)
+	case ".cells":
+		ioError = pattern.EncodeCells(file, world)
+	case ".lif", ".life":
+		ioError = pattern.EncodeLife106(file, world)
+	default:
+		panic("Unknown pattern format " + filename)
+	}
+	util.Check(ioError)
+	util.Check(file.Sync())
+
+	fmt.Println("File", filename, "output done!")
 }
 
 // startIo should be the entrypoint of the io goroutine.
@@ -138,9 +277,9 @@ func startIo(p Params, c ioChannels) {
 		case command := <-io.channels.command:
 			switch command {
 			case ioInput:
-				io.readPgmImage()
+				io.readImage()
 			case ioOutput:
-				io.writePgmImage()
+				io.writeImage()
 			case ioCheckIdle:
 				io.channels.idle <- true
 			}
@@ -0,0 +1,86 @@
+package kernel
+
+import "uk.ac.bris.cs/gameoflife/util"
+
+// shifted returns word i of row moved one cell to the right and one cell to the left, so bit x of
+// west holds the cell at x-1 and bit x of east holds the cell at x+1, wrapping around the row.
+func shifted(row []uint64, i, width int) (west, east uint64) {
+	last := len(row) - 1
+	west = row[i] << 1
+	if i > 0 {
+		west |= row[i-1] >> 63
+	} else {
+		west |= row[last] >> uint((width-1)%64) & 1
+	}
+
+	east = row[i] >> 1
+	if i < last {
+		east |= row[i+1] << 63
+	} else {
+		east |= (row[0] & 1) << uint((width-1)%64)
+	}
+	return
+}
+
+// add adds a single bit from each of 64 cells to the 4 bit neighbour counts held in s0 to s3.
+func add(s0, s1, s2, s3 *uint64, x uint64) {
+	carry := *s0 & x
+	*s0 ^= x
+	x = carry
+	carry = *s1 & x
+	*s1 ^= x
+	x = carry
+	carry = *s2 & x
+	*s2 ^= x
+	*s3 |= carry
+}
+
+// Bitwise counts the neighbours of 64 cells at once, adding the 8 neighbouring rows shifted into line
+// with bit-sliced adders. Each rule's neighbour counts are then matched against the sums word by word.
+func Bitwise(world, next util.BitGrid, rule util.Rule, startY, endY int) {
+	stride := world.Stride()
+	lastMask := ^uint64(0) >> uint(63-(world.Width-1)%64)
+
+	for y := startY; y < endY; y++ {
+		up, row, down := world.Row(y), world.Row(y+1), world.Row(y+2)
+		rows := [3][]uint64{up, row, down}
+		out := next.Row(y)
+		for i := 0; i < stride; i++ {
+			var s0, s1, s2, s3 uint64
+			for _, r := range rows {
+				west, east := shifted(r, i, world.Width)
+				add(&s0, &s1, &s2, &s3, west)
+				add(&s0, &s1, &s2, &s3, east)
+			}
+			add(&s0, &s1, &s2, &s3, up[i])
+			add(&s0, &s1, &s2, &s3, down[i])
+
+			sums := [4]uint64{s0, s1, s2, s3}
+			var born, survive uint64
+			for n := uint(0); n <= 8; n++ {
+				if (rule.Birth|rule.Survive)&(1<<n) == 0 {
+					continue
+				}
+				match := ^uint64(0)
+				for bit, sum := range sums {
+					if n&(1<<uint(bit)) != 0 {
+						match &= sum
+					} else {
+						match &^= sum
+					}
+				}
+				if rule.Birth&(1<<n) != 0 {
+					born |= match
+				}
+				if rule.Survive&(1<<n) != 0 {
+					survive |= match
+				}
+			}
+
+			out[i] = row[i]&survive | ^row[i]&born
+			if i == stride-1 {
+				out[i] &= lastMask
+			}
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package kernel
+
+import (
+	"errors"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Kernel computes rows startY up to endY of next, one turn on from world.
+// world has a halo row above and below the rows being computed, so row y of next is worked out from
+// rows y, y+1 and y+2 of world. Columns wrap around, as the world is a closed domain.
+type Kernel func(world, next util.BitGrid, rule util.Rule, startY, endY int)
+
+// ByName returns the kernel selected by a command line flag, either "naive" or "bitwise".
+func ByName(name string) (Kernel, error) {
+	switch name {
+	case "naive":
+		return Naive, nil
+	case "bitwise":
+		return Bitwise, nil
+	}
+	return nil, errors.New("unknown kernel " + name + ", expected naive or bitwise")
+}
+
+// WithHalo returns world with the top halo row added above it and the bottom halo row below it.
+func WithHalo(world util.BitGrid, top, bottom []uint64) util.BitGrid {
+	rows := util.NewBitGrid(world.Width, world.Height+2)
+	copy(rows.Row(0), top)
+	copy(rows.Rows(1, world.Height+1).Words, world.Words)
+	copy(rows.Row(world.Height+1), bottom)
+	return rows
+}
+
+// Step returns the whole world one turn on, wrapping around both vertically and horizontally.
+func Step(k Kernel, world util.BitGrid, rule util.Rule) util.BitGrid {
+	next := util.NewBitGrid(world.Width, world.Height)
+	k(WithHalo(world, world.Row(world.Height-1), world.Row(0)), next, rule, 0, world.Height)
+	return next
+}
+
+func getLiveNeighbours(width, height int, world util.BitGrid, x, y int) int {
+	var alive = 0
+	var widthLeft int
+	var widthRight int
+	var heightUp int
+	var heightDown int
+
+	if x == 0 {
+		widthLeft = width - 1
+	} else {
+		widthLeft = x - 1
+	}
+	if x == width-1 {
+		widthRight = 0
+	} else {
+		widthRight = x + 1
+	}
+
+	if y == 0 {
+		heightUp = height - 1
+	} else {
+		heightUp = y - 1
+	}
+
+	if y == height-1 {
+		heightDown = 0
+	} else {
+		heightDown = y + 1
+	}
+
+	if world.Get(widthLeft, y) {
+		alive = alive + 1
+	}
+	if world.Get(widthRight, y) {
+		alive = alive + 1
+	}
+	if world.Get(widthLeft, heightUp) {
+		alive = alive + 1
+	}
+	if world.Get(x, heightUp) {
+		alive = alive + 1
+	}
+	if world.Get(widthRight, heightUp) {
+		alive = alive + 1
+	}
+	if world.Get(widthLeft, heightDown) {
+		alive = alive + 1
+	}
+	if world.Get(x, heightDown) {
+		alive = alive + 1
+	}
+	if world.Get(widthRight, heightDown) {
+		alive = alive + 1
+	}
+	return alive
+}
+
+// Naive counts the neighbours of each cell one at a time.
+func Naive(world, next util.BitGrid, rule util.Rule, startY, endY int) {
+	for y := startY; y < endY; y++ {
+		for x := 0; x < world.Width; x++ {
+			neighbours := getLiveNeighbours(world.Width, world.Height, world, x, y+1)
+			next.Set(x, y, rule.Next(world.Get(x, y+1), neighbours))
+		}
+	}
+}
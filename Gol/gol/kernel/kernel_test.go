@@ -0,0 +1,53 @@
+package kernel
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol/testworld"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestKernels checks both kernels against the 16x16, 64x64 and 512x512 images after 1 and 100 turns.
+func TestKernels(t *testing.T) {
+	kernels := map[string]Kernel{"naive": Naive, "bitwise": Bitwise}
+	for _, size := range []int{16, 64, 512} {
+		for name, k := range kernels {
+			for _, turns := range []int{1, 100} {
+				t.Run(fmt.Sprintf("%dx%dx%d-%s", size, size, turns, name), func(t *testing.T) {
+					world := testworld.Read(t, fmt.Sprintf("../../images/%dx%d.pgm", size, size))
+					for turn := 0; turn < turns; turn++ {
+						world = Step(k, world, util.Conway)
+					}
+					testworld.AssertEqual(t, world, testworld.Read(t, fmt.Sprintf("../../check/images/%dx%dx%d.pgm", size, size, turns)))
+				})
+			}
+		}
+	}
+}
+
+// TestBitwiseMatchesNaive runs random worlds of awkward widths through both kernels under several rules.
+func TestBitwiseMatchesNaive(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, rulestring := range []string{"B3/S23", "B36/S23", "B2/S", "B3678/S34678", "B0123478/S01234678", "B12345678/S012345678"} {
+		rule, err := util.ParseRule(rulestring)
+		util.Check(err)
+		for _, width := range []int{1, 2, 3, 31, 63, 64, 65, 127, 128, 131} {
+			t.Run(fmt.Sprintf("%s-%d", rulestring, width), func(t *testing.T) {
+				world := util.NewBitGrid(width, 9)
+				for y := 0; y < world.Height; y++ {
+					for x := 0; x < width; x++ {
+						world.Set(x, y, random.Intn(3) == 0)
+					}
+				}
+				naive, bitwise := world, world
+				for turn := 0; turn < 5; turn++ {
+					naive = Step(Naive, naive, rule)
+					bitwise = Step(Bitwise, bitwise, rule)
+					testworld.AssertEqual(t, bitwise, naive)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package kernel
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol/testworld"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// BenchmarkKernels compares the naive and bitwise kernels on a single turn of the 512x512 image.
+// The halo is built outside the timed loop so only the kernels themselves are measured.
+func BenchmarkKernels(b *testing.B) {
+	world := testworld.Read(b, "../../images/512x512.pgm")
+	rows := WithHalo(world, world.Row(world.Height-1), world.Row(0))
+	next := util.NewBitGrid(world.Width, world.Height)
+
+	for _, name := range []string{"naive", "bitwise"} {
+		k, err := ByName(name)
+		util.Check(err)
+		b.Run(fmt.Sprintf("%dx%d-%s", world.Width, world.Height, name), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				k(rows, next, util.Conway, 0, world.Height)
+			}
+		})
+	}
+}
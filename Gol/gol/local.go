@@ -0,0 +1,130 @@
+package gol
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol/hashlife"
+	"uk.ac.bris.cs/gameoflife/gol/kernel"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// localWorker computes rows startY up to endY of next. rows holds the world with a halo row above and below it.
+func localWorker(rows, next util.BitGrid, rule util.Rule, startY, endY int, done chan<- bool) {
+	kernel.Bitwise(rows, next, rule, startY, endY)
+	done <- true
+}
+
+// nextLocalWorld returns world one turn on, splitting the rows between threads worker goroutines.
+func nextLocalWorld(world util.BitGrid, rule util.Rule, threads int) util.BitGrid {
+	rows := kernel.WithHalo(world, world.Row(world.Height-1), world.Row(0))
+
+	workers := threads
+	if workers > world.Height {
+		workers = world.Height
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	next := util.NewBitGrid(world.Width, world.Height)
+	done := make(chan bool)
+	workerHeight := world.Height / workers
+	for i := 0; i < workers; i++ {
+		endY := workerHeight * (i + 1)
+		if i == workers-1 {
+			endY = world.Height
+		}
+		go localWorker(rows, next, rule, workerHeight*i, endY, done)
+	}
+	for i := 0; i < workers; i++ {
+		<-done
+	}
+	return next
+}
+
+// runLocal runs the Game of Life in this process, for when there is no broker to run on.
+// It sends the same events as running on a broker, except that CellFlipped and TurnComplete are sent for every
+// turn, or every HashLife jump, rather than only for the turns the broker's diffs reach.
+// However the run ends, the world is saved and sent in a FinalTurnComplete.
+func runLocal(p Params, c distributorChannels, world util.BitGrid) {
+	rule := p.Rule.OrDefault()
+	for _, cell := range world.AliveCells() {
+		c.events <- CellFlipped{0, cell}
+	}
+
+	// With HashLife each step jumps as many turns as it can, as on the broker.
+	var life *hashlife.Life
+	if p.HashLife {
+		life = hashlife.New(world, rule)
+	}
+
+	ticker := time.NewTicker(2 * time.Second)
+	turn := 0
+	paused := false
+	quit := false
+
+	for turn < p.Turns && !quit {
+		if paused {
+			// Only resuming and saving are allowed while paused, as with the broker.
+			switch <-c.keyPresses {
+			case 'p':
+				paused = false
+				fmt.Println("Workers resumed at turn: " + strconv.Itoa(turn))
+				c.events <- StateChange{turn, Executing}
+			case 's':
+				saveWorld(p, c, world, turn)
+			default:
+				fmt.Println("All execution currently paused. Please resume to carry on.")
+			}
+			continue
+		}
+
+		select {
+		case <-ticker.C:
+			c.events <- AliveCellsCount{turn, world.AliveCount()}
+		case kp := <-c.keyPresses:
+			switch kp {
+			case 'p':
+				paused = true
+				fmt.Println("Workers paused at turn: " + strconv.Itoa(turn))
+				c.events <- StateChange{turn, Paused}
+			case 'q':
+				fmt.Println("Quitting at turn: " + strconv.Itoa(turn))
+				quit = true
+			case 's':
+				saveWorld(p, c, world, turn)
+			case 'k':
+				// There are no engines to shut down, so this quits like q.
+				fmt.Println("Quitting at turn: " + strconv.Itoa(turn))
+				quit = true
+			}
+		default:
+			var next util.BitGrid
+			if life != nil {
+				turn += life.Advance(p.Turns - turn)
+				next = life.World()
+			} else {
+				next = nextLocalWorld(world, rule, p.Threads)
+				turn++
+			}
+			for _, cell := range world.Xor(next).AliveCells() {
+				c.events <- CellFlipped{turn, cell}
+			}
+			c.events <- TurnComplete{turn}
+			world = next
+		}
+	}
+	ticker.Stop()
+
+	saveWorld(p, c, world, turn)
+	c.events <- FinalTurnComplete{turn, world.AliveCells()}
+
+	// Make sure that the Io has finished any output before exiting.
+	c.ioCommand <- ioCheckIdle
+	<-c.ioIdle
+
+	c.events <- StateChange{turn, Quitting}
+	close(c.events)
+}
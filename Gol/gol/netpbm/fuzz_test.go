@@ -0,0 +1,40 @@
+//go:build go1.18
+// +build go1.18
+
+package netpbm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// FuzzDecode checks Decode never panics, and that any world it decodes encodes and decodes back unchanged.
+func FuzzDecode(f *testing.F) {
+	for _, seed := range []string{
+		"P1\n# comment\n3 2\n0 1 0\n1 0 1\n",
+		"P2\n3 2\n15\n15 0 8\n7 9 0\n",
+		"P4\n3 2\n\x5f\xbf",
+		"P5\n3 2\n255\n\xff\x20\xff\x0a\xff\x09",
+		"P5\n2 1\n65535\n\xff\xff\x00\x00",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, image []byte) {
+		world, err := Decode(bytes.NewReader(image))
+		if err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		if err = Encode(&buf, world); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("re-encoded image rejected: %v", err)
+		}
+		if decoded.Width != world.Width || decoded.Height != world.Height || !reflect.DeepEqual(decoded.Words, world.Words) {
+			t.Fatalf("%dx%d world changed by re-encoding", world.Width, world.Height)
+		}
+	})
+}
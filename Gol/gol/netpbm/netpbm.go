@@ -0,0 +1,243 @@
+// Package netpbm reads and writes worlds as Netpbm images, with alive cells white and dead cells black.
+// It reads bitmaps (PBM) and graymaps (PGM) in both their ASCII and binary forms, and writes binary PGMs.
+package netpbm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// MaxDimension is the widest or tallest image Decode accepts, so a bad header can't ask for a huge world.
+const MaxDimension = 1 << 16
+
+// maxMaxval is the largest maxval a PGM image may have, with two bytes to each binary pixel.
+const maxMaxval = 65535
+
+// errEnd is returned by token and bit when the image ends before the field they were reading.
+var errEnd = errors.New("image ends early")
+
+// Decode reads a PBM or PGM image, plain (P1, P2) or raw (P4, P5). In a graymap a pixel brighter than half of
+// maxval is an alive cell. In a bitmap 1 is black, so a 0 bit is an alive cell. Comments are allowed anywhere
+// in the header and, in plain images, among the pixels.
+//
+// Rows are only allocated as they are read, so an image which claims to be much larger than it is fails
+// without taking the memory its header asks for.
+func Decode(r io.Reader) (util.BitGrid, error) {
+	br := bufio.NewReader(r)
+	magic, width, height, maxval, err := decodeHeader(br)
+	if err != nil {
+		return util.BitGrid{}, err
+	}
+
+	var readRow func(row util.BitGrid) error
+	switch magic {
+	case "P1":
+		readRow = func(row util.BitGrid) error {
+			for x := 0; x < width; x++ {
+				b, err := bit(br)
+				if err != nil {
+					return err
+				}
+				row.Set(x, 0, !b)
+			}
+			return nil
+		}
+	case "P2":
+		readRow = func(row util.BitGrid) error {
+			for x := 0; x < width; x++ {
+				t, err := token(br)
+				if err != nil {
+					return err
+				}
+				pixel, err := strconv.Atoi(t)
+				if err != nil || pixel < 0 || pixel > maxval {
+					return fmt.Errorf("bad pixel %q", t)
+				}
+				row.Set(x, 0, 2*pixel > maxval)
+			}
+			return nil
+		}
+	case "P4":
+		raw := make([]byte, (width+7)/8)
+		readRow = func(row util.BitGrid) error {
+			if _, err := io.ReadFull(br, raw); err != nil {
+				return errEnd
+			}
+			for x := 0; x < width; x++ {
+				row.Set(x, 0, raw[x/8]&(0x80>>uint(x%8)) == 0)
+			}
+			return nil
+		}
+	case "P5":
+		size := 1
+		if maxval > 255 {
+			size = 2
+		}
+		raw := make([]byte, size*width)
+		readRow = func(row util.BitGrid) error {
+			if _, err := io.ReadFull(br, raw); err != nil {
+				return errEnd
+			}
+			for x := 0; x < width; x++ {
+				pixel := int(raw[x])
+				if size == 2 {
+					pixel = int(raw[2*x])<<8 | int(raw[2*x+1])
+				}
+				if pixel > maxval {
+					return fmt.Errorf("bad pixel %v", pixel)
+				}
+				row.Set(x, 0, 2*pixel > maxval)
+			}
+			return nil
+		}
+	}
+
+	world := util.BitGrid{Width: width, Height: height}
+	for y := 0; y < height; y++ {
+		row := util.NewBitGrid(width, 1)
+		if err := readRow(row); err == errEnd {
+			return util.BitGrid{}, fmt.Errorf("image ends at row %v of %v", y, height)
+		} else if err != nil {
+			return util.BitGrid{}, fmt.Errorf("%v at row %v", err, y)
+		}
+		world.Words = append(world.Words, row.Words...)
+	}
+	return world, nil
+}
+
+// DecodeConfig reads just the header of a PBM or PGM image, returning the size of the world it holds.
+func DecodeConfig(r io.Reader) (width, height int, err error) {
+	_, width, height, _, err = decodeHeader(bufio.NewReader(r))
+	return
+}
+
+// decodeHeader reads the magic number, size and maxval of an image, leaving r at the start of its pixels.
+// Bitmaps have a maxval of 1.
+func decodeHeader(r *bufio.Reader) (magic string, width, height, maxval int, err error) {
+	magic, err = token(r)
+	if err != nil {
+		return "", 0, 0, 0, headerError(err)
+	}
+	fields := []string{"width", "height", "maxval"}
+	switch magic {
+	case "P1", "P4":
+		fields = fields[:2]
+	case "P2", "P5":
+	default:
+		return "", 0, 0, 0, errors.New("not a PBM or PGM image")
+	}
+
+	header := []int{0, 0, 1}
+	for i, name := range fields {
+		t, err := token(r)
+		if err != nil {
+			return "", 0, 0, 0, headerError(err)
+		}
+		header[i], err = strconv.Atoi(t)
+		if err != nil || header[i] < 1 {
+			return "", 0, 0, 0, fmt.Errorf("bad %v %q", name, t)
+		}
+	}
+	width, height, maxval = header[0], header[1], header[2]
+	if width > MaxDimension || height > MaxDimension {
+		return "", 0, 0, 0, fmt.Errorf("%vx%v is larger than %vx%v", width, height, MaxDimension, MaxDimension)
+	}
+	if maxval > maxMaxval {
+		return "", 0, 0, 0, fmt.Errorf("maxval %v is larger than %v", maxval, maxMaxval)
+	}
+	return magic, width, height, maxval, nil
+}
+
+func headerError(err error) error {
+	if err == errEnd {
+		return errors.New("image ends in its header")
+	}
+	return err
+}
+
+// maxToken is the longest field token reads, longer than any sensible width, height, maxval or pixel.
+const maxToken = 20
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+}
+
+// skipComment skips the rest of a comment line, after its #.
+func skipComment(r *bufio.Reader) error {
+	if _, err := r.ReadString('\n'); err != nil {
+		return errEnd
+	}
+	return nil
+}
+
+// token reads the next whitespace separated field, skipping comments. The single whitespace character
+// ending the field is consumed, so after the maxval the reader is at the start of the pixels.
+func token(r *bufio.Reader) (string, error) {
+	var t []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(t) > 0 {
+				return string(t), nil
+			}
+			return "", errEnd
+		}
+		switch {
+		case c == '#' && len(t) == 0:
+			if err := skipComment(r); err != nil {
+				return "", err
+			}
+		case isSpace(c):
+			if len(t) > 0 {
+				return string(t), nil
+			}
+		default:
+			if len(t) == maxToken {
+				return "", errors.New("field is too long")
+			}
+			t = append(t, c)
+		}
+	}
+}
+
+// bit reads the next pixel of a plain bitmap, where pixels needn't be separated by whitespace.
+func bit(r *bufio.Reader) (bool, error) {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return false, errEnd
+		}
+		switch {
+		case c == '0' || c == '1':
+			return c == '1', nil
+		case c == '#':
+			if err := skipComment(r); err != nil {
+				return false, err
+			}
+		case !isSpace(c):
+			return false, fmt.Errorf("bad pixel %q", c)
+		}
+	}
+}
+
+// Encode writes world as a binary (P5) PGM image.
+func Encode(w io.Writer, world util.BitGrid) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P5\n%d %d\n255\n", world.Width, world.Height)
+	row := make([]byte, world.Width)
+	for y := 0; y < world.Height; y++ {
+		for x := range row {
+			row[x] = 0
+			if world.Get(x, y) {
+				row[x] = 255
+			}
+		}
+		bw.Write(row)
+	}
+	return bw.Flush()
+}
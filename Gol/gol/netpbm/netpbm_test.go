@@ -0,0 +1,81 @@
+package netpbm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestRoundTrip encodes worlds and checks they decode unchanged, including from a header with comments in it.
+func TestRoundTrip(t *testing.T) {
+	world := util.BitGridFromCells([]util.Cell{{X: 0, Y: 0}, {X: 1, Y: 2}, {X: 69, Y: 2}}, 70, 3)
+	var buf bytes.Buffer
+	util.Check(Encode(&buf, world))
+
+	decoded, err := Decode(&buf)
+	util.Check(err)
+	if decoded.Width != 70 || decoded.Height != 3 || len(decoded.AliveCells()) != 3 || !decoded.Get(69, 2) {
+		t.Errorf("decoded %v alive cells in %dx%d", decoded.AliveCells(), decoded.Width, decoded.Height)
+	}
+
+	commented := append([]byte("P5\n# made by hand\n2 1 # width and height\n255\n"), 0, 255)
+	decoded, err = Decode(bytes.NewReader(commented))
+	util.Check(err)
+	if decoded.Get(0, 0) || !decoded.Get(1, 0) {
+		t.Errorf("decoded %v from a commented header", decoded.AliveCells())
+	}
+}
+
+// TestFormats checks each kind of image decodes to the same world, including pixels which are whitespace bytes.
+func TestFormats(t *testing.T) {
+	// A 3x2 world with (0, 0), (2, 0) and (1, 1) alive.
+	for _, image := range []string{
+		"P1\n# plain bitmap\n3 2\n0 1 0\n1 0 1\n",
+		"P1 3 2 010101",
+		"P4\n3 2\n\x5f\xbf",
+		"P2\n3 2\n15\n15 0 8\n# a comment among the pixels\n7 9 0\n",
+		"P5\n3 2\n255\n\xff\x20\xff\x0a\xff\x09",
+		"P5 3 2 1 \x01\x00\x01\x00\x01\x00",
+		"P5\n3 2\n65535\n\xff\xff\x00\x20\x80\x00\x7f\xff\x80\x00\x00\x0a",
+	} {
+		world, err := Decode(bytes.NewReader([]byte(image)))
+		if err != nil {
+			t.Errorf("%q: %v", image, err)
+			continue
+		}
+		width, height, err := DecodeConfig(bytes.NewReader([]byte(image)))
+		if err != nil || width != 3 || height != 2 {
+			t.Errorf("%q: header gave %vx%v, %v", image, width, height, err)
+		}
+		want := util.BitGridFromCells([]util.Cell{{X: 0, Y: 0}, {X: 2, Y: 0}, {X: 1, Y: 1}}, 3, 2)
+		if world.Width != 3 || world.Height != 2 || !reflect.DeepEqual(world.Words, want.Words) {
+			t.Errorf("%q decoded to %v alive cells in %dx%d", image, world.AliveCells(), world.Width, world.Height)
+		}
+	}
+}
+
+// TestBadImages checks images which aren't PBMs or PGMs, are out of range, or are cut short, are rejected.
+func TestBadImages(t *testing.T) {
+	for _, image := range []string{
+		"",
+		"P3\n1 1\n255\n0 0 0",
+		"P5\n0 1\n255\n",
+		"P5\n1 1\n65536\n\x00\x00",
+		"P5\n1 1\n1000\n\xff\xff",
+		"P5\n4 4\n255\n\x00\x00",
+		"P5\n1000000 1000000\n255\n",
+		"P5\n60000 60000\n255\n\x00",
+		"P5\n1",
+		"P2\n2 1\n255\n0 256",
+		"P2\n2 1\n255\n0 x",
+		"P2\n2 1\n255\n0",
+		"P1\n2 1\n0 2",
+		"P4\n9 1\n\x00",
+	} {
+		if _, err := Decode(bytes.NewReader([]byte(image))); err == nil {
+			t.Errorf("expected %q to be rejected", image)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package pattern
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// DecodeCells reads a plaintext pattern: lines starting with ! are comments, and every other line is a row of
+// dead (.) and alive (O) cells. Rows may leave off their trailing dead cells, so the pattern is as wide as its
+// longest row. * is accepted for alive cells too, as some older files use it.
+func DecodeCells(r io.Reader) (Pattern, error) {
+	var p Pattern
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		if p.Height == MaxDimension || len(line) > MaxDimension {
+			return Pattern{}, fmt.Errorf("pattern is larger than %vx%v", MaxDimension, MaxDimension)
+		}
+		for x, c := range []byte(line) {
+			switch c {
+			case '.':
+			case 'O', '*':
+				p.Cells = append(p.Cells, util.Cell{X: x, Y: p.Height})
+			default:
+				return Pattern{}, fmt.Errorf("unexpected %q in row %v", c, p.Height)
+			}
+		}
+		if len(line) > p.Width {
+			p.Width = len(line)
+		}
+		p.Height++
+	}
+	if err := scanner.Err(); err != nil {
+		return Pattern{}, err
+	}
+	if p.Height == 0 {
+		return Pattern{}, errors.New("pattern has no rows")
+	}
+	return p, nil
+}
+
+// EncodeCells writes world as a plaintext pattern the size of the whole world, leaving off dead cells at the
+// end of each row.
+func EncodeCells(w io.Writer, world util.BitGrid) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "!Name: %dx%d\n", world.Width, world.Height)
+	row := make([]byte, world.Width)
+	for y := 0; y < world.Height; y++ {
+		end := 0
+		for x := range row {
+			row[x] = '.'
+			if world.Get(x, y) {
+				row[x] = 'O'
+				end = x + 1
+			}
+		}
+		bw.Write(row[:end])
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
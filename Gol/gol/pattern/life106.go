@@ -0,0 +1,79 @@
+package pattern
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// life106Header starts every Life 1.06 file.
+const life106Header = "#Life 1.06"
+
+// DecodeLife106 reads a Life 1.06 pattern, a header line followed by the x and y coordinates of an alive cell on
+// each line. The coordinates are kept as they are, so the pattern's origin is the file's origin.
+func DecodeLife106(r io.Reader) (Pattern, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != life106Header {
+		return Pattern{}, errors.New("pattern doesn't start with " + life106Header)
+	}
+
+	var p Pattern
+	var minX, minY, maxX, maxY int
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return Pattern{}, fmt.Errorf("line %v should be an x and a y coordinate", line)
+		}
+		x, errX := strconv.Atoi(fields[0])
+		y, errY := strconv.Atoi(fields[1])
+		if errX != nil || errY != nil || x <= -MaxDimension || x >= MaxDimension || y <= -MaxDimension || y >= MaxDimension {
+			return Pattern{}, fmt.Errorf("bad coordinates on line %v", line)
+		}
+		if len(p.Cells) == 0 {
+			minX, minY, maxX, maxY = x, y, x, y
+		}
+		minX, minY = min(minX, x), min(minY, y)
+		maxX, maxY = max(maxX, x), max(maxY, y)
+		p.Cells = append(p.Cells, util.Cell{X: x, Y: y})
+	}
+	if err := scanner.Err(); err != nil {
+		return Pattern{}, err
+	}
+	if len(p.Cells) > 0 {
+		p.Width, p.Height = maxX-minX+1, maxY-minY+1
+	}
+	return p, nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// EncodeLife106 writes the alive cells of world as a Life 1.06 pattern, with the world's top left corner as
+// the origin.
+func EncodeLife106(w io.Writer, world util.BitGrid) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(life106Header + "\n")
+	for _, cell := range world.AliveCells() {
+		fmt.Fprintf(bw, "%d %d\n", cell.X, cell.Y)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,35 @@
+// Package pattern holds patterns read from the text formats used to share Game of Life patterns, and reads and
+// writes two of them: the LifeWiki plaintext format (.cells) and the Life 1.06 coordinate list (.lif).
+// The RLE format has a package of its own, rle.
+package pattern
+
+import (
+	"fmt"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// MaxDimension is the widest or tallest pattern the decoders accept.
+const MaxDimension = 1 << 16
+
+// Pattern is a decoded pattern. Width and Height are the size of the box it fits in, which holds every one of
+// Cells. Life 1.06 cells may be anywhere, including at negative coordinates. Rule is the zero Rule if the
+// format didn't give one.
+type Pattern struct {
+	Width, Height int
+	Rule          util.Rule
+	Cells         []util.Cell
+}
+
+// BitGrid places the pattern on a world of the given size with its origin at (x, y),
+// wrapping cells off the edges round to the other side.
+func (p Pattern) BitGrid(width, height, x, y int) (util.BitGrid, error) {
+	if p.Width > width || p.Height > height {
+		return util.BitGrid{}, fmt.Errorf("%vx%v pattern doesn't fit on a %vx%v board", p.Width, p.Height, width, height)
+	}
+	world := util.NewBitGrid(width, height)
+	for _, cell := range p.Cells {
+		world.Set(((cell.X+x)%width+width)%width, ((cell.Y+y)%height+height)%height, true)
+	}
+	return world, nil
+}
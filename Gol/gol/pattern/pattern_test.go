@@ -0,0 +1,70 @@
+package pattern
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// assertWorld checks the pattern placed at the origin of a 10x10 board has exactly the expected cells alive.
+func assertWorld(t *testing.T, p Pattern, expected []util.Cell) {
+	world, err := p.BitGrid(10, 10, 0, 0)
+	util.Check(err)
+	if world.AliveCount() != len(expected) {
+		t.Fatalf("expected %v alive, got %v", expected, world.AliveCells())
+	}
+	for _, cell := range expected {
+		if !world.Get(cell.X, cell.Y) {
+			t.Fatalf("expected %v alive, got %v", expected, world.AliveCells())
+		}
+	}
+}
+
+var glider = []util.Cell{{X: 1, Y: 0}, {X: 2, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}, {X: 2, Y: 2}}
+
+// TestCells reads a glider with comments and short rows, and checks a world round trips through the format.
+func TestCells(t *testing.T) {
+	p, err := DecodeCells(strings.NewReader("!Name: Glider\n!\n.O\n..O\nOOO\n"))
+	util.Check(err)
+	if p.Width != 3 || p.Height != 3 {
+		t.Errorf("expected a 3x3 pattern, got %dx%d", p.Width, p.Height)
+	}
+	assertWorld(t, p, glider)
+
+	var buf bytes.Buffer
+	util.Check(EncodeCells(&buf, util.BitGridFromCells(glider, 10, 10)))
+	p, err = DecodeCells(&buf)
+	util.Check(err)
+	assertWorld(t, p, glider)
+
+	for _, bad := range []string{"", "!Only a comment\n", ".O\nOXO\n"} {
+		if _, err = DecodeCells(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+// TestLife106 reads an R-pentomino around the origin, wrapping onto the far edges of the board,
+// and checks a world round trips through the format.
+func TestLife106(t *testing.T) {
+	p, err := DecodeLife106(strings.NewReader("#Life 1.06\n0 -1\n1 -1\n-1 0\n0 0\n\n0 1\n"))
+	util.Check(err)
+	if p.Width != 3 || p.Height != 3 {
+		t.Errorf("expected a 3x3 pattern, got %dx%d", p.Width, p.Height)
+	}
+	assertWorld(t, p, []util.Cell{{X: 0, Y: 9}, {X: 1, Y: 9}, {X: 9, Y: 0}, {X: 0, Y: 0}, {X: 0, Y: 1}})
+
+	var buf bytes.Buffer
+	util.Check(EncodeLife106(&buf, util.BitGridFromCells(glider, 10, 10)))
+	p, err = DecodeLife106(&buf)
+	util.Check(err)
+	assertWorld(t, p, glider)
+
+	for _, bad := range []string{"", "0 0\n", "#Life 1.05\n0 0\n", "#Life 1.06\n0\n", "#Life 1.06\n0 x\n", "#Life 1.06\n0 99999999\n"} {
+		if _, err = DecodeLife106(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
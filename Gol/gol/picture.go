@@ -0,0 +1,47 @@
+package gol
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// DefaultAliveColour and DefaultDeadColour are the colours of cells in PNG and GIF output unless Params say otherwise.
+var (
+	DefaultAliveColour = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	DefaultDeadColour  = color.RGBA{A: 255}
+)
+
+// ParseColour reads a colour written as six hex digits, as in #ff8800, with or without the #.
+func ParseColour(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return color.RGBA{}, errors.New("bad colour " + strconv.Quote(s) + ", expected one like #ff8800")
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+// palette is the two colours pictures of the world are drawn in, dead then alive.
+func palette(p Params) color.Palette {
+	return color.Palette{p.DeadColour, p.AliveColour}
+}
+
+// renderWorld draws world with each cell a square Scale pixels across, in the colours the params give.
+func renderWorld(p Params, world util.BitGrid) *image.Paletted {
+	scale := p.Scale
+	picture := image.NewPaletted(image.Rect(0, 0, world.Width*scale, world.Height*scale), palette(p))
+	for _, cell := range world.AliveCells() {
+		for y := cell.Y * scale; y < (cell.Y+1)*scale; y++ {
+			row := picture.Pix[y*picture.Stride : (y+1)*picture.Stride]
+			for x := cell.X * scale; x < (cell.X+1)*scale; x++ {
+				row[x] = 1
+			}
+		}
+	}
+	return picture
+}
@@ -0,0 +1,177 @@
+package gol
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// frameDelay is how long each recorded turn is shown for in an animated GIF, in hundredths of a second.
+const frameDelay = 10
+
+// recorder keeps the world as the CellFlipped and TurnComplete events show it, drawing a frame every Nth turn.
+type recorder struct {
+	p     Params
+	world util.BitGrid
+	turn  int
+
+	next   int          // the turn the next frame is due on
+	framed int          // the turn of the last frame drawn
+	last   util.BitGrid // the world in the last frame drawn
+	frames int
+	gif    gif.GIF
+	failed bool
+}
+
+// record passes every event sent on the channel it returns on to events, recording the world to p.Record as it
+// goes. events is closed once the returned channel is, after the recording has been written.
+func record(p Params, events chan<- Event) chan<- Event {
+	tap := make(chan Event, cap(events))
+	r := &recorder{p: p, world: util.NewBitGrid(p.ImageWidth, p.ImageHeight), framed: -1}
+	if !r.isGIF() {
+		if err := os.MkdirAll(p.Record, os.ModePerm); err != nil {
+			r.fail(err)
+		}
+	}
+	go func() {
+		for event := range tap {
+			r.event(event)
+			events <- event
+		}
+		r.finish()
+		close(events)
+	}()
+	return tap
+}
+
+// isGIF reports whether the recording is an animated GIF, rather than a directory of numbered PNG frames.
+func (r *recorder) isGIF() bool {
+	return strings.EqualFold(filepath.Ext(r.p.Record), ".gif")
+}
+
+// fail reports an error recording. The run carries on, but nothing more is recorded.
+func (r *recorder) fail(err error) {
+	fmt.Println("Recording to " + r.p.Record + " failed: " + err.Error())
+	r.failed = true
+}
+
+// event applies an event to the recorded world. Before the world moves on to a later turn, a frame is drawn
+// of it if one is due, so turns which change no cells are still recorded.
+func (r *recorder) event(event Event) {
+	switch e := event.(type) {
+	case CellFlipped:
+		r.advance(e.CompletedTurns)
+		r.world.Set(e.Cell.X, e.Cell.Y, !r.world.Get(e.Cell.X, e.Cell.Y))
+	case TurnComplete:
+		r.advance(e.CompletedTurns)
+		r.frameIfDue()
+	case FinalTurnComplete:
+		r.advance(e.CompletedTurns)
+		r.world = util.BitGridFromCells(e.Alive, r.world.Width, r.world.Height)
+		// The final world is always recorded, even when it isn't on an Nth turn.
+		if r.framed != r.turn {
+			r.frame()
+		}
+	}
+}
+
+// advance moves the recorded world on to turn, first drawing a frame of it as it was if one is due.
+func (r *recorder) advance(turn int) {
+	if turn != r.turn {
+		r.frameIfDue()
+		r.turn = turn
+	}
+}
+
+func (r *recorder) frameIfDue() {
+	if r.turn >= r.next {
+		r.frame()
+	}
+}
+
+// frame draws the world as it is now, as a frame of the GIF or a PNG in the recording's directory.
+func (r *recorder) frame() {
+	r.next = (r.turn/r.p.RecordEvery + 1) * r.p.RecordEvery
+	r.framed = r.turn
+	if r.failed {
+		return
+	}
+	picture := renderWorld(r.p, r.world)
+	if !r.isGIF() {
+		r.frames++
+		if err := writePNG(filepath.Join(r.p.Record, fmt.Sprintf("%06d.png", r.frames-1)), picture); err != nil {
+			r.fail(err)
+		}
+		return
+	}
+
+	// After the first frame each frame only covers the cells which have changed since the last one,
+	// drawn over it, which keeps a GIF of a large sparse world small.
+	bounds := picture.Bounds()
+	if r.frames > 0 {
+		changed := r.world.Xor(r.last).AliveCells()
+		if len(changed) == 0 {
+			r.gif.Delay[len(r.gif.Delay)-1] += frameDelay
+			return
+		}
+		var area image.Rectangle
+		for _, cell := range changed {
+			area = area.Union(image.Rect(cell.X, cell.Y, cell.X+1, cell.Y+1))
+		}
+		bounds = image.Rect(area.Min.X*r.p.Scale, area.Min.Y*r.p.Scale, area.Max.X*r.p.Scale, area.Max.Y*r.p.Scale)
+	}
+	// The changed area is copied out so the rest of the picture isn't kept in memory until the GIF is written.
+	area := image.NewPaletted(bounds, picture.Palette)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		copy(area.Pix[area.PixOffset(bounds.Min.X, y):area.PixOffset(bounds.Max.X-1, y)+1], picture.Pix[picture.PixOffset(bounds.Min.X, y):])
+	}
+	r.frames++
+	r.gif.Image = append(r.gif.Image, area)
+	r.gif.Delay = append(r.gif.Delay, frameDelay)
+	r.gif.Disposal = append(r.gif.Disposal, gif.DisposalNone)
+	r.last = util.BitGrid{Width: r.world.Width, Height: r.world.Height, Words: append([]uint64(nil), r.world.Words...)}
+}
+
+// finish writes out the GIF once the run is over.
+func (r *recorder) finish() {
+	if r.failed || !r.isGIF() || r.frames == 0 {
+		return
+	}
+	r.gif.Config = image.Config{
+		ColorModel: palette(r.p),
+		Width:      r.world.Width * r.p.Scale,
+		Height:     r.world.Height * r.p.Scale,
+	}
+	file, err := os.Create(r.p.Record)
+	if err == nil {
+		err = gif.EncodeAll(file, &r.gif)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		r.fail(err)
+		return
+	}
+	fmt.Println("Recorded " + strconv.Itoa(r.frames) + " frames to " + r.p.Record)
+}
+
+// writePNG writes picture as a PNG image at path.
+func writePNG(path string, picture image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = png.Encode(file, picture); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
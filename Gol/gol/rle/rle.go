@@ -0,0 +1,183 @@
+// Package rle reads and writes patterns in the Run Length Encoded format used by most Game of Life software.
+// A pattern is a header such as "x = 3, y = 3, rule = B3/S23" followed by runs of dead (b) and alive (o) cells,
+// with $ ending each row and ! ending the pattern. A count before a tag repeats it, as in "3o2$".
+package rle
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/gol/pattern"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// MaxDimension is the widest or tallest pattern Decode accepts.
+const MaxDimension = pattern.MaxDimension
+
+// maxLine is the longest line Encode writes, as the format asks.
+const maxLine = 70
+
+// Decode reads an RLE pattern. Comment lines starting with # are skipped. The pattern's rule is the zero Rule
+// if the header doesn't give one. Tags other than b and o are treated as alive cells, as for patterns with
+// more than two states.
+func Decode(r io.Reader) (pattern.Pattern, error) {
+	br := bufio.NewReader(r)
+	var p pattern.Pattern
+	header := false
+	row, column := 0, 0
+	count := 0
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return pattern.Pattern{}, err
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "#") || trimmed == "":
+		case !header:
+			if p, err = parseHeader(trimmed); err != nil {
+				return pattern.Pattern{}, err
+			}
+			header = true
+		default:
+			for _, c := range trimmed {
+				switch {
+				case c >= '0' && c <= '9':
+					count = count*10 + int(c-'0')
+					if count > MaxDimension {
+						return pattern.Pattern{}, errors.New("run of " + strconv.Itoa(count) + " is too long")
+					}
+				case c == ' ' || c == '\t':
+				case c == '!':
+					return p, nil
+				case c == '$':
+					row += max(count, 1)
+					column = 0
+					count = 0
+				default:
+					run := max(count, 1)
+					if c != 'b' && c != '.' {
+						if row >= p.Height || column+run > p.Width {
+							return pattern.Pattern{}, fmt.Errorf("cells at row %v, column %v lie outside the %vx%v pattern", row, column+run-1, p.Width, p.Height)
+						}
+						for i := 0; i < run; i++ {
+							p.Cells = append(p.Cells, util.Cell{X: column + i, Y: row})
+						}
+					}
+					column += run
+					count = 0
+				}
+			}
+		}
+		if err == io.EOF {
+			if !header {
+				return pattern.Pattern{}, errors.New("pattern has no x = , y = header")
+			}
+			// Plenty of patterns in the wild leave off the final !.
+			return p, nil
+		}
+	}
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// parseHeader reads a header line such as "x = 3, y = 3, rule = B3/S23".
+func parseHeader(line string) (pattern.Pattern, error) {
+	var p pattern.Pattern
+	seen := map[string]bool{}
+	for _, field := range strings.Split(line, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return pattern.Pattern{}, errors.New("bad header field " + strconv.Quote(field))
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		seen[name] = true
+		var err error
+		switch name {
+		case "x":
+			p.Width, err = strconv.Atoi(value)
+		case "y":
+			p.Height, err = strconv.Atoi(value)
+		case "rule":
+			// Drop any bounded grid suffix, as in B3/S23:T100,100.
+			if i := strings.IndexByte(value, ':'); i >= 0 {
+				value = value[:i]
+			}
+			p.Rule, err = util.ParseRule(value)
+		}
+		if err != nil {
+			return pattern.Pattern{}, fmt.Errorf("bad %v %q in header", name, value)
+		}
+	}
+	if !seen["x"] || !seen["y"] {
+		return pattern.Pattern{}, errors.New("pattern has no x = , y = header")
+	}
+	if p.Width < 0 || p.Height < 0 || p.Width > MaxDimension || p.Height > MaxDimension {
+		return pattern.Pattern{}, fmt.Errorf("pattern size %vx%v is out of range", p.Width, p.Height)
+	}
+	return p, nil
+}
+
+// lineWriter writes runs, wrapping lines before they get longer than maxLine.
+type lineWriter struct {
+	w      *bufio.Writer
+	length int
+}
+
+func (l *lineWriter) run(count int, tag byte) {
+	s := string(tag)
+	if count > 1 {
+		s = strconv.Itoa(count) + s
+	}
+	if l.length+len(s) > maxLine {
+		l.w.WriteByte('\n')
+		l.length = 0
+	}
+	l.w.WriteString(s)
+	l.length += len(s)
+}
+
+// Encode writes world as an RLE pattern the size of the whole world, with rule in its header.
+func Encode(w io.Writer, world util.BitGrid, rule util.Rule) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "x = %d, y = %d, rule = %v\n", world.Width, world.Height, rule.OrDefault())
+	l := &lineWriter{w: bw}
+
+	// Dead cells at the end of a row and empty rows are left for the $ runs to cover.
+	endedRows := 0
+	for y := 0; y < world.Height; y++ {
+		column := 0
+		for x := 0; x < world.Width; {
+			alive := world.Get(x, y)
+			run := 1
+			for x+run < world.Width && world.Get(x+run, y) == alive {
+				run++
+			}
+			if alive {
+				if endedRows > 0 {
+					l.run(endedRows, '$')
+					endedRows = 0
+				}
+				if x > column {
+					l.run(x-column, 'b')
+				}
+				l.run(run, 'o')
+				column = x + run
+			}
+			x += run
+		}
+		endedRows++
+	}
+	l.run(1, '!')
+	bw.WriteByte('\n')
+	return bw.Flush()
+}
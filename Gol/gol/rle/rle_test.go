@@ -0,0 +1,91 @@
+package rle
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestDecode reads a glider with comments, a rule and a line break, placing it across the edge of a board.
+func TestDecode(t *testing.T) {
+	pattern, err := Decode(strings.NewReader("#N Glider\n#C A comment\nx = 3, y = 3, rule = B36/S23\nbo$2bo$\n3o!\n"))
+	util.Check(err)
+	if pattern.Width != 3 || pattern.Height != 3 || pattern.Rule.String() != "B36/S23" {
+		t.Errorf("expected a 3x3 B36/S23 pattern, got %dx%d %v", pattern.Width, pattern.Height, pattern.Rule)
+	}
+	world, err := pattern.BitGrid(8, 8, 6, 0)
+	util.Check(err)
+	expected := []util.Cell{{X: 7, Y: 0}, {X: 0, Y: 1}, {X: 6, Y: 2}, {X: 7, Y: 2}, {X: 0, Y: 2}}
+	for _, cell := range expected {
+		if !world.Get(cell.X, cell.Y) {
+			t.Errorf("expected %v to be alive, got %v", cell, world.AliveCells())
+		}
+	}
+	if world.AliveCount() != len(expected) {
+		t.Errorf("expected %d alive cells, got %v", len(expected), world.AliveCells())
+	}
+}
+
+// TestRoundTrip encodes a world with long runs and empty rows and checks it decodes unchanged.
+func TestRoundTrip(t *testing.T) {
+	world := util.NewBitGrid(100, 10)
+	for x := 0; x < 90; x++ {
+		world.Set(x, 0, true)
+	}
+	world.Set(99, 0, true)
+	world.Set(5, 4, true)
+	world.Set(0, 9, true)
+	for x := 0; x < 100; x += 2 {
+		world.Set(x, 7, true)
+	}
+
+	var buf bytes.Buffer
+	util.Check(Encode(&buf, world, util.Rule{}))
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if len(line) > maxLine {
+			t.Errorf("line %q is longer than %d characters", line, maxLine)
+		}
+	}
+
+	pattern, err := Decode(&buf)
+	util.Check(err)
+	if pattern.Rule != util.Conway {
+		t.Errorf("expected an unset rule to be saved as Conway's, got %v", pattern.Rule)
+	}
+	decoded, err := pattern.BitGrid(100, 10, 0, 0)
+	util.Check(err)
+	given, expected := decoded.AliveCells(), world.AliveCells()
+	if len(given) != len(expected) {
+		t.Fatalf("expected %d alive cells, got %d", len(expected), len(given))
+	}
+	for i := range given {
+		if given[i] != expected[i] {
+			t.Fatalf("expected alive cell %v, got %v", expected[i], given[i])
+		}
+	}
+}
+
+// TestBadPatterns checks patterns without a proper header, or with cells outside their size, are rejected.
+func TestBadPatterns(t *testing.T) {
+	for _, pattern := range []string{
+		"",
+		"bo$2bo$3o!",
+		"x = 3\nbo!",
+		"x = 3, y = 3, rule = B9/S23\nbo!",
+		"x = 3, y = 3\n4o!",
+		"x = 3, y = 3\n3$o!",
+		"x = 3, y = 3\n99999999o!",
+	} {
+		if _, err := Decode(strings.NewReader(pattern)); err == nil {
+			t.Errorf("expected %q to be rejected", pattern)
+		}
+	}
+
+	pattern, err := Decode(strings.NewReader("x = 3, y = 3\n3o!"))
+	util.Check(err)
+	if _, err = pattern.BitGrid(2, 2, 0, 0); err == nil {
+		t.Error("expected a pattern larger than the board to be rejected")
+	}
+}
@@ -0,0 +1,60 @@
+package stubs
+
+import (
+	"math/bits"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// WorldDiff is the change in a job's world from turn From to turn Turn, for a controller to draw.
+// Sparse changes are sent as the index y*Width+x of every flipped cell, dense ones as a mask of the flipped cells,
+// whichever is smaller. If Reset is set the diff is from an empty world instead, because the broker no longer
+// had the world the controller asked for a diff from.
+// Working is false once the job has finished, after which there are no more diffs to come.
+type WorldDiff struct {
+	From, Turn    int
+	Width, Height int
+	Reset         bool
+	Working       bool
+	AliveCount    int
+	Flipped       []uint32
+	Mask          util.BitGrid
+}
+
+// NewWorldDiff returns the diff from world from to world to, which must be the same size.
+func NewWorldDiff(from, to util.BitGrid) WorldDiff {
+	mask := from.Xor(to)
+	d := WorldDiff{Width: to.Width, Height: to.Height}
+	count := mask.AliveCount()
+	// Each index takes up to 4 bytes, against 8 bytes for every word of the mask.
+	if count*4 >= len(mask.Words)*8 {
+		d.Mask = mask
+		return d
+	}
+	d.Flipped = make([]uint32, 0, count)
+	stride := mask.Stride()
+	for i, word := range mask.Words {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			d.Flipped = append(d.Flipped, uint32(i/stride*to.Width+i%stride*64+bit))
+			word &= word - 1
+		}
+	}
+	return d
+}
+
+// Apply returns world with the diff applied, leaving world itself unchanged.
+func (d WorldDiff) Apply(world util.BitGrid) util.BitGrid {
+	next := util.NewBitGrid(d.Width, d.Height)
+	if !d.Reset {
+		copy(next.Words, world.Words)
+	}
+	if d.Mask.Words != nil {
+		return next.Xor(d.Mask)
+	}
+	for _, i := range d.Flipped {
+		x, y := int(i)%d.Width, int(i)/d.Width
+		next.Set(x, y, !next.Get(x, y))
+	}
+	return next
+}
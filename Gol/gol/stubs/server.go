@@ -0,0 +1,74 @@
+package stubs
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+)
+
+// Server serves the GolEngine RPCs of a broker or an engine on a TCP address.
+// Closing it drops every open connection too, so clients see it go away just as if its process had died.
+type Server struct {
+	listener net.Listener
+	rpc      *rpc.Server
+	m        sync.Mutex
+	conns    []net.Conn
+	closed   bool
+}
+
+// Serve starts serving rcvr's methods as the GolEngine service on addr. Pass port 0 for an ephemeral port.
+func Serve(addr string, rcvr interface{}) (*Server, error) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("GolEngine", rcvr); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: listener, rpc: server}
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.m.Lock()
+		if s.closed {
+			s.m.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns = append(s.conns, conn)
+		s.m.Unlock()
+		go s.rpc.ServeConn(conn)
+	}
+}
+
+// Addr returns the address the server is listening on, with the actual port if it was started on port 0.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Port returns the port the server is listening on.
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops listening and closes every connection. It is safe to call more than once.
+func (s *Server) Close() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.listener.Close()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
@@ -1,6 +1,10 @@
 package stubs
 
-import "uk.ac.bris.cs/gameoflife/util"
+import (
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
 
 var ProcessTurns = "GolEngine.ProcessTurns"
 var DoTick = "GolEngine.DoTick"
@@ -10,36 +14,102 @@ var InterruptEngine = "GolEngine.InterruptEngine"
 var CheckStatus = "GolEngine.CheckStatus"
 var KillEngine = "GolEngine.KillEngine"
 var ProcessTurn = "GolEngine.ProcessTurn"
+var LoadStrip = "GolEngine.LoadStrip"
+var GetStrip = "GolEngine.GetStrip"
+var RegisterEngine = "GolEngine.RegisterEngine"
+var DeregisterEngine = "GolEngine.DeregisterEngine"
+var SubmitJob = "GolEngine.SubmitJob"
+var AwaitJob = "GolEngine.AwaitJob"
+var CollectJob = "GolEngine.CollectJob"
+var DropStrip = "GolEngine.DropStrip"
+var ListJobs = "GolEngine.ListJobs"
+var WatchJob = "GolEngine.WatchJob"
 
+// GolArgs is a job for the broker. Image is the name of the image the world was read from, kept to describe the job.
 type GolArgs struct {
-	World                [][]byte
+	Image                string
+	World                util.BitGrid
 	Width, Height, Turns int
 	Threads              int
 	Engines              int
+	Rule                 util.Rule
+	HashLife             bool
+}
+
+// JobInfo describes a job on the broker, for controllers looking for one to attach to.
+// The JSON tags name its fields in the broker's HTTP API, as do those of TickReport and EngineStatus.
+type JobInfo struct {
+	ID      int       `json:"id"`
+	Image   string    `json:"image"`
+	Width   int       `json:"width"`
+	Height  int       `json:"height"`
+	Turns   int       `json:"turns"`
+	Turn    int       `json:"turn"`
+	Working bool      `json:"working"`
+	Paused  bool      `json:"paused"`
+	Started time.Time `json:"started"`
 }
 
+// JobArgs picks out one of the jobs running on a broker, by the ID SubmitJob returned for it.
+// Engines are sent it too, as they keep a separate strip for every job.
+type JobArgs struct {
+	Job int
+}
+
+// WatchArgs asks for the change in a job's world since turn Since, the turn of the last diff the controller applied.
+// A Since of -1 means the controller has no world yet.
+type WatchArgs struct {
+	Job   int
+	Since int
+}
+
+// StripArgs is a band of rows of the world, starting at row Offset, which an engine keeps between turns.
+// Threads is how many worker goroutines the engine should split the strip between, using Rule to update cells.
+type StripArgs struct {
+	Job     int
+	Offset  int
+	Strip   util.BitGrid
+	Threads int
+	Rule    util.Rule
+}
+
+// EngineArgs holds the packed halo rows an engine needs to process its strip for one turn:
+// the row directly above its strip and the row directly below it.
 type EngineArgs struct {
-	TotalWorld      [][]byte
-	TWidth, THeight int
-	Height          int
-	Offset          int
+	Job         int
+	Top, Bottom []uint64
+}
+
+// EngineRegistration is sent by an engine to the broker when it starts up and shuts down.
+// Address is where the broker can dial the engine, Capacity is how much work it can take on.
+type EngineRegistration struct {
+	Address  string
+	Capacity int
 }
 
+// EngineResponse holds the packed first and last rows of an engine's strip after a turn, to be sent on as halos.
 type EngineResponse struct {
-	AliveCells []util.Cell
+	Top, Bottom []uint64
+	AliveCount  int
 }
 
+// GolAliveCells holds the world after TurnsComplete turns.
 type GolAliveCells struct {
 	TurnsComplete int
-	AliveCells    []util.Cell
+	World         util.BitGrid
 }
 
 type TickReport struct {
-	Turns      int
-	AliveCount int
+	Turns      int `json:"turns"`
+	AliveCount int `json:"alive_count"`
 }
 
+// EngineStatus is the state of a job. Recovered jobs were resumed from a checkpoint at RecoveredTurn
+// after the broker restarted.
 type EngineStatus struct {
-	Working bool
-	Turn    int
+	Working       bool `json:"working"`
+	Paused        bool `json:"paused"`
+	Turn          int  `json:"turn"`
+	Recovered     bool `json:"recovered"`
+	RecoveredTurn int  `json:"recovered_turn"`
 }
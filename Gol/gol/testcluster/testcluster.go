@@ -0,0 +1,60 @@
+// Package testcluster runs a broker and GOL Engines inside the test process on loopback ephemeral ports,
+// so the distributed Game of Life can be tested end to end without starting any other processes.
+package testcluster
+
+import (
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol/broker"
+	"uk.ac.bris.cs/gameoflife/gol/golengine"
+	"uk.ac.bris.cs/gameoflife/gol/kernel"
+)
+
+// Cluster is a broker along with the engines registered with it.
+type Cluster struct {
+	Broker  *broker.Broker
+	Engines []*golengine.Engine
+	t       testing.TB
+}
+
+// Start starts a broker and n engines registered with it, failing the test if any of them can't start.
+// The engines use the bitwise kernel. Everything is stopped when the test finishes.
+func Start(t testing.TB, n int) *Cluster {
+	b := broker.New()
+	if err := b.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start broker: %v", err)
+	}
+	c := &Cluster{Broker: b, t: t}
+	t.Cleanup(c.Stop)
+
+	for i := 0; i < n; i++ {
+		c.AddEngine()
+	}
+	return c
+}
+
+// Addr returns the address controllers should connect to the broker on.
+func (c *Cluster) Addr() string {
+	return c.Broker.Addr()
+}
+
+// AddEngine starts another engine and registers it with the broker, which includes it from the next turn.
+func (c *Cluster) AddEngine() *golengine.Engine {
+	e := golengine.New(kernel.Bitwise)
+	if err := e.Start("127.0.0.1:0"); err != nil {
+		c.t.Fatalf("failed to start engine: %v", err)
+	}
+	c.Engines = append(c.Engines, e)
+	if err := e.Register(c.Addr(), "127.0.0.1", 1); err != nil {
+		c.t.Fatalf("failed to register engine: %v", err)
+	}
+	return e
+}
+
+// Stop stops the broker and every engine. It is safe to call more than once.
+func (c *Cluster) Stop() {
+	for _, e := range c.Engines {
+		e.Stop()
+	}
+	c.Broker.Stop()
+}
@@ -0,0 +1,48 @@
+// Package testworld reads the test images and compares worlds against them, for the tests of the packages which
+// can't share test files with each other.
+package testworld
+
+import (
+	"os"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol/netpbm"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Read reads a PBM or PGM test image into a packed world, failing the test if it can't.
+func Read(t testing.TB, path string) util.BitGrid {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	world, err := netpbm.Decode(file)
+	if err != nil {
+		t.Fatalf("%v: %v", path, err)
+	}
+	return world
+}
+
+// AssertEqual checks given is the same size as expected, with the same cells alive.
+func AssertEqual(t testing.TB, given, expected util.BitGrid) {
+	t.Helper()
+	if given.Width != expected.Width || given.Height != expected.Height {
+		t.Fatalf("expected a %dx%d world, got %dx%d", expected.Width, expected.Height, given.Width, given.Height)
+	}
+	AssertEqualCells(t, given.AliveCells(), expected.AliveCells())
+}
+
+// AssertEqualCells checks the same cells are alive, both lists being ordered by row then column.
+func AssertEqualCells(t testing.TB, given, expected []util.Cell) {
+	t.Helper()
+	if len(given) != len(expected) {
+		t.Fatalf("expected %d alive cells, got %d", len(expected), len(given))
+	}
+	for i, cell := range given {
+		if cell != expected[i] {
+			t.Fatalf("expected alive cell %v, got %v", expected[i], cell)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// Package websocket is a small implementation of the WebSocket protocol (RFC 6455), enough for the broker to
+// stream a job to a browser. It has no extensions and no subprotocols.
+//
+// It is written here rather than taken from golang.org/x/net/websocket or gorilla/websocket so the broker and
+// engines keep building with nothing but the standard library, on machines which can't fetch modules; SDL is the
+// module's only dependency, and only the visualiser needs it. It does what those packages do by default to keep
+// a broker on an open port safe: Upgrade refuses handshakes a browser makes from a page on another host, so no
+// other site can read a job through a visitor's browser, and every frame's length is checked against the
+// connection's read limit before any of it is read, so a peer can't make it allocate more than the limit.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Opcode is the type of a frame.
+type Opcode byte
+
+const (
+	continuation Opcode = 0x0
+	Text         Opcode = 0x1
+	Binary       Opcode = 0x2
+	closeFrame   Opcode = 0x8
+	ping         Opcode = 0x9
+	pong         Opcode = 0xa
+)
+
+// MaxMessage is the longest message ReadMessage accepts unless SetReadLimit says otherwise, enough for the whole
+// of a 16384x16384 board as a mask.
+const MaxMessage = 64 << 20
+
+// maxControl is the longest payload a ping, pong or close frame may have.
+const maxControl = 125
+
+// acceptGUID is appended to the client's key to make the accept key, proving the server speaks WebSocket.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrClosed is returned by ReadMessage once the other end has closed the connection.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// Conn is a WebSocket connection. One goroutine may read from it while others write.
+type Conn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	client bool // clients mask every frame they send
+	limit  int  // the longest message ReadMessage accepts
+	wm     sync.Mutex
+}
+
+// SetReadLimit sets the longest message ReadMessage accepts, MaxMessage by default. A longer message fails
+// ReadMessage before its payload is read.
+func (c *Conn) SetReadLimit(limit int) {
+	c.limit = limit
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range strings.Split(h.Get(name), ",") {
+		if strings.EqualFold(strings.TrimSpace(value), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether a handshake comes from a page on the host it was sent to. Requests with no Origin
+// header aren't from a browser, so they are let through.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// Upgrade completes the handshake for a WebSocket request, taking over its connection.
+// If the request isn't a WebSocket handshake, or comes from a page on another host, it is answered with an error
+// and Upgrade fails.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket handshake", http.StatusBadRequest)
+		return nil, errors.New("websocket: not a handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: unsupported version")
+	}
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin WebSocket requests aren't allowed", http.StatusForbidden)
+		return nil, errors.New("websocket: origin " + r.Header.Get("Origin") + " doesn't match host " + r.Host)
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection can't be taken over", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Conn{conn: conn, r: rw.Reader, limit: MaxMessage}, nil
+}
+
+// Dial opens a WebSocket connection to path on the server at addr.
+func Dial(addr, path string) (*Conn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+path, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, errors.New("websocket: handshake refused with " + resp.Status)
+	}
+	return &Conn{conn: conn, r: r, client: true, limit: MaxMessage}, nil
+}
+
+// writeFrame sends a single, final frame.
+func (c *Conn) writeFrame(op Opcode, data []byte) error {
+	c.wm.Lock()
+	defer c.wm.Unlock()
+
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | byte(op)
+	switch {
+	case len(data) < 126:
+		header[1] = byte(len(data))
+	case len(data) <= 0xffff:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(data)))
+	default:
+		header[1] = 127
+		header = append(header, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(data)))
+	}
+	if c.client {
+		header[1] |= 0x80
+		mask := make([]byte, 4)
+		rand.Read(mask)
+		header = append(header, mask...)
+		masked := make([]byte, len(data))
+		for i, b := range data {
+			masked[i] = b ^ mask[i%4]
+		}
+		data = masked
+	}
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// WriteMessage sends data as a single Text or Binary message.
+func (c *Conn) WriteMessage(op Opcode, data []byte) error {
+	return c.writeFrame(op, data)
+}
+
+// readFrame reads the next frame, unmasking its payload. A frame longer than the read limit, or a control frame
+// longer than maxControl, is refused before its payload is read.
+func (c *Conn) readFrame() (fin bool, op Opcode, data []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.r, header[:]); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	op = Opcode(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		err = errors.New("websocket: frame masked wrongly")
+		return
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err = io.ReadFull(c.r, extended[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err = io.ReadFull(c.r, extended[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if op >= closeFrame && length > maxControl {
+		err = errors.New("websocket: control frame too long")
+		return
+	}
+	if length > uint64(c.limit) {
+		err = errors.New("websocket: message too long")
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+			return
+		}
+	}
+	data = make([]byte, length)
+	if _, err = io.ReadFull(c.r, data); err != nil {
+		return
+	}
+	if masked {
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// ReadMessage reads the next Text or Binary message, putting fragmented messages back together.
+// Pings are answered as they arrive. Once the other end closes the connection ReadMessage returns ErrClosed.
+func (c *Conn) ReadMessage() (Opcode, []byte, error) {
+	var message []byte
+	var messageOp Opcode
+	for {
+		fin, op, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case ping:
+			if err = c.writeFrame(pong, data); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case pong:
+			continue
+		case closeFrame:
+			c.writeFrame(closeFrame, data)
+			return 0, nil, ErrClosed
+		case continuation:
+			if messageOp == 0 {
+				return 0, nil, errors.New("websocket: continuation with no message to continue")
+			}
+		default:
+			if messageOp != 0 {
+				return 0, nil, errors.New("websocket: new message before the last one finished")
+			}
+			messageOp = op
+		}
+		if len(message)+len(data) > c.limit {
+			return 0, nil, errors.New("websocket: message too long")
+		}
+		message = append(message, data...)
+		if fin {
+			return messageOp, message, nil
+		}
+	}
+}
+
+// Close tells the other end the connection is closing, then closes it.
+func (c *Conn) Close() error {
+	c.writeFrame(closeFrame, nil)
+	return c.conn.Close()
+}
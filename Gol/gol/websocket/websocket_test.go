@@ -0,0 +1,119 @@
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// TestEcho sends messages of every length encoding through a server which echoes them back, answering a ping
+// along the way, then checks the server sees the connection close.
+func TestEcho(t *testing.T) {
+	serverClosed := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				serverClosed <- err
+				return
+			}
+			conn.WriteMessage(op, data)
+		}
+	}))
+	defer server.Close()
+
+	conn, err := Dial(strings.TrimPrefix(server.URL, "http://"), "/")
+	util.Check(err)
+	util.Check(conn.writeFrame(ping, []byte("ping")))
+	for _, length := range []int{0, 125, 126, 65535, 65536} {
+		message := bytes.Repeat([]byte{'x'}, length)
+		util.Check(conn.WriteMessage(Binary, message))
+		op, echoed, err := conn.ReadMessage()
+		util.Check(err)
+		if op != Binary || !bytes.Equal(echoed, message) {
+			t.Errorf("sent %d bytes, got %d back", length, len(echoed))
+		}
+	}
+
+	util.Check(conn.Close())
+	if err = <-serverClosed; err != ErrClosed {
+		t.Errorf("expected the server to see the connection close, got %v", err)
+	}
+}
+
+// TestNotAHandshake checks a plain request to a WebSocket endpoint is refused.
+func TestNotAHandshake(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Upgrade(w, r)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	util.Check(err)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a plain request to be refused, got status %d", resp.StatusCode)
+	}
+}
+
+// TestCrossOrigin checks a handshake from a page on another host is refused, while one from the same host isn't.
+func TestCrossOrigin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := Upgrade(w, r); err == nil {
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	for origin, status := range map[string]int{
+		"http://elsewhere.example":                            http.StatusForbidden,
+		"http://" + strings.TrimPrefix(server.URL, "http://"): http.StatusSwitchingProtocols,
+	} {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		util.Check(err)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Origin", origin)
+		resp, err := http.DefaultClient.Do(req)
+		util.Check(err)
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("expected a handshake from %v to get status %d, got %d", origin, status, resp.StatusCode)
+		}
+	}
+}
+
+// TestReadLimit checks a message longer than the read limit is refused.
+func TestReadLimit(t *testing.T) {
+	serverErr := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadLimit(16)
+		_, _, err = conn.ReadMessage()
+		serverErr <- err
+	}))
+	defer server.Close()
+
+	conn, err := Dial(strings.TrimPrefix(server.URL, "http://"), "/")
+	util.Check(err)
+	defer conn.Close()
+	util.Check(conn.WriteMessage(Binary, make([]byte, 17)))
+	if err = <-serverErr; err == nil || err == ErrClosed {
+		t.Errorf("expected a message over the read limit to be refused, got %v", err)
+	}
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"runtime"
+	"strings"
 	"time"
 	"uk.ac.bris.cs/gameoflife/gol"
 	"uk.ac.bris.cs/gameoflife/sdl"
+	"uk.ac.bris.cs/gameoflife/util"
 )
 
 // main is the function called when starting Game of Life with 'go run .'
@@ -14,6 +17,12 @@ func main() {
 	runtime.LockOSThread()
 	var params gol.Params
 
+	flag.IntVar(
+		&params.Threads,
+		"t",
+		2,
+		"Specify the number of worker threads each engine uses. Defaults to 2.")
+
 	flag.IntVar(
 		&params.ImageWidth,
 		"w",
@@ -32,6 +41,103 @@ func main() {
 		10,
 		"Specify the number of turns to process. Defaults to 10000000000.")
 
+	rule := flag.String(
+		"rule",
+		"",
+		"Specify the Life-like rule in B/S notation, e.g. B36/S23 for HighLife. Defaults to the rule in an RLE pattern's header, or Conway's B3/S23.")
+
+	flag.BoolVar(
+		&params.HashLife,
+		"hashlife",
+		false,
+		"Run with HashLife on the broker, jumping many turns at a time. Best for sparse patterns and huge turn counts.")
+
+	flag.StringVar(
+		&params.BrokerAddr,
+		"broker",
+		gol.DefaultBrokerAddr,
+		"Specify the address of the broker to run on. Defaults to "+gol.DefaultBrokerAddr+". Pass an empty address to run without a broker.")
+
+	flag.IntVar(
+		&params.AttachJob,
+		"attach",
+		0,
+		"Specify the ID of a job running on the broker to attach to, instead of starting a new one.")
+
+	flag.StringVar(
+		&params.Pattern,
+		"pattern",
+		"",
+		"Specify a pattern in images/ to start from instead of an image, e.g. gosperglidergun or glider.cells. The board is still -w by -h.")
+
+	flag.IntVar(
+		&params.PatternX,
+		"px",
+		0,
+		"Specify the column to place the left edge of the pattern at.")
+
+	flag.IntVar(
+		&params.PatternY,
+		"py",
+		0,
+		"Specify the row to place the top edge of the pattern at.")
+
+	flag.StringVar(
+		&params.Input,
+		"input",
+		"",
+		"Specify the path of an image or pattern to start from, of any name. Its format is given by its extension, from .pgm, .pbm, .rle, .cells and .lif, and the board is the size its header gives, overriding -w and -h.")
+
+	flag.StringVar(
+		&params.OutDir,
+		"out",
+		gol.DefaultOutDir,
+		"Specify the directory to save the world in. Defaults to "+gol.DefaultOutDir+".")
+
+	flag.StringVar(
+		&params.OutName,
+		"outname",
+		gol.DefaultOutName,
+		"Specify the name to save the world under, less its extension. {width}, {height}, {turns} and {name}, the input's name less its extension, are filled in. Defaults to "+gol.DefaultOutName+".")
+
+	saveAs := flag.String(
+		"saveas",
+		"",
+		"Specify a comma separated list of formats to save the world in as well as a PGM image, from "+strings.Join(gol.SaveFormats, ", ")+".")
+
+	flag.IntVar(
+		&params.Scale,
+		"scale",
+		1,
+		"Specify the size of each cell in pixels in PNG images and recordings.")
+
+	aliveColour := flag.String(
+		"alive",
+		"#ffffff",
+		"Specify the colour of alive cells in PNG images and recordings. Defaults to white.")
+
+	deadColour := flag.String(
+		"dead",
+		"#000000",
+		"Specify the colour of dead cells in PNG images and recordings. Defaults to black.")
+
+	flag.StringVar(
+		&params.Record,
+		"record",
+		"",
+		"Specify where to record the run to: an animated GIF if it ends in .gif, otherwise a directory of numbered PNG frames.")
+
+	flag.IntVar(
+		&params.RecordEvery,
+		"every",
+		1,
+		"Specify how many turns apart the frames of a recording are.")
+
+	listJobs := flag.Bool(
+		"jobs",
+		false,
+		"List the jobs on the broker and exit.")
+
 	noVis := flag.Bool(
 		"noVis",
 		true,
@@ -39,8 +145,63 @@ func main() {
 
 	flag.Parse()
 
-	params.Threads = 2
+	var err error
+	if *rule != "" {
+		if params.Rule, err = util.ParseRule(*rule); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if params.AliveColour, err = gol.ParseColour(*aliveColour); err == nil {
+		params.DeadColour, err = gol.ParseColour(*deadColour)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *saveAs != "" {
+		params.SaveAs = strings.Split(*saveAs, ",")
+		if err = gol.CheckSaveAs(params.SaveAs); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
 	params.Engines = 1
+	params.Watch = !*noVis
+
+	if *listJobs {
+		jobs, err := gol.ListJobs(params.BrokerAddr)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, job := range jobs {
+			fmt.Printf("Job %d: %s, %dx%d, turn %d of %d, working: %v, started %v\n",
+				job.ID, job.Image, job.Width, job.Height, job.Turn, job.Turns, job.Working, job.Started.Format(time.RFC1123))
+		}
+		return
+	}
+
+	if params.Input != "" {
+		if params.Pattern != "" {
+			fmt.Println("Only one of -input and -pattern can be given")
+			os.Exit(1)
+		}
+		if params, err = gol.InputParams(params); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if params.AttachJob != 0 {
+		if params, err = gol.AttachParams(params); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
 	fmt.Println("Width:", params.ImageWidth)
 	fmt.Println("Height:", params.ImageHeight)
@@ -52,14 +213,13 @@ func main() {
 	if !(*noVis) {
 		sdl.Run(params, events, keyPresses)
 	} else {
-		complete := false
-		for !complete {
-			event := <-events
-			switch event.(type) {
+		for event := range events {
+			switch e := event.(type) {
 			case gol.FinalTurnComplete:
-				complete = true
 				fmt.Println("Finished distributor at time: ")
 				fmt.Println(time.Now())
+			case gol.ErrorOccurred:
+				fmt.Println(e)
 			}
 		}
 	}
@@ -0,0 +1,123 @@
+package util
+
+import "math/bits"
+
+// BitGrid is a world packed one bit per cell, 64 cells to a word, so it is cheap to send over RPC.
+// Each row starts on a new word and the unused bits at the end of a row are always zero.
+// Bit x%64 of word x/64 in a row holds the cell in column x.
+type BitGrid struct {
+	Width, Height int
+	Words         []uint64
+}
+
+// NewBitGrid returns an empty world of the given size.
+func NewBitGrid(width, height int) BitGrid {
+	return BitGrid{Width: width, Height: height, Words: make([]uint64, height*wordsPerRow(width))}
+}
+
+func wordsPerRow(width int) int {
+	return (width + 63) / 64
+}
+
+// Stride is the number of words used by each row.
+func (g BitGrid) Stride() int {
+	return wordsPerRow(g.Width)
+}
+
+// Row returns the words of row y. Changing them changes the grid.
+func (g BitGrid) Row(y int) []uint64 {
+	stride := g.Stride()
+	return g.Words[y*stride : (y+1)*stride]
+}
+
+// Rows returns the grid made of rows y0 up to y1, sharing its words with g.
+func (g BitGrid) Rows(y0, y1 int) BitGrid {
+	stride := g.Stride()
+	return BitGrid{Width: g.Width, Height: y1 - y0, Words: g.Words[y0*stride : y1*stride]}
+}
+
+// Get reports whether the cell at (x, y) is alive.
+func (g BitGrid) Get(x, y int) bool {
+	return g.Words[y*g.Stride()+x/64]&(1<<uint(x%64)) != 0
+}
+
+// Set makes the cell at (x, y) alive or dead.
+func (g BitGrid) Set(x, y int, alive bool) {
+	i := y*g.Stride() + x/64
+	if alive {
+		g.Words[i] |= 1 << uint(x%64)
+	} else {
+		g.Words[i] &^= 1 << uint(x%64)
+	}
+}
+
+// AliveCount returns the number of alive cells.
+func (g BitGrid) AliveCount() int {
+	count := 0
+	for _, word := range g.Words {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// AliveCells lists the alive cells, ordered by row then column.
+func (g BitGrid) AliveCells() []Cell {
+	cells := []Cell{}
+	stride := g.Stride()
+	for y := 0; y < g.Height; y++ {
+		for i, word := range g.Words[y*stride : (y+1)*stride] {
+			for word != 0 {
+				bit := bits.TrailingZeros64(word)
+				cells = append(cells, Cell{X: i*64 + bit, Y: y})
+				word &= word - 1
+			}
+		}
+	}
+	return cells
+}
+
+// Xor returns a grid with the cells alive in exactly one of g and h, which must be the same size.
+// These are the cells which flip going from one world to the other.
+func (g BitGrid) Xor(h BitGrid) BitGrid {
+	x := NewBitGrid(g.Width, g.Height)
+	for i, word := range g.Words {
+		x.Words[i] = word ^ h.Words[i]
+	}
+	return x
+}
+
+// Bytes unpacks the grid into PGM style rows, with 0xff for alive cells and 0x00 for dead ones.
+func (g BitGrid) Bytes() [][]byte {
+	world := make([][]byte, g.Height)
+	for y := range world {
+		world[y] = make([]byte, g.Width)
+		for x := range world[y] {
+			if g.Get(x, y) {
+				world[y][x] = 0xff
+			}
+		}
+	}
+	return world
+}
+
+// BitGridFromBytes packs PGM style rows, treating any non-zero byte as an alive cell.
+func BitGridFromBytes(world [][]byte, width, height int) BitGrid {
+	g := NewBitGrid(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if world[y][x] != 0 {
+				g.Set(x, y, true)
+			}
+		}
+	}
+	return g
+}
+
+// BitGridFromCells packs a list of alive cells into a world of the given size.
+func BitGridFromCells(cells []Cell, width, height int) BitGrid {
+	g := NewBitGrid(width, height)
+	for _, cell := range cells {
+		g.Set(cell.X, cell.Y, true)
+	}
+	return g
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestBitGrid packs worlds whose widths do and don't fill whole words, and checks they unpack unchanged.
+func TestBitGrid(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, width := range []int{1, 16, 63, 64, 65, 127, 200} {
+		height := 7
+		world := make([][]byte, height)
+		var cells []Cell
+		for y := range world {
+			world[y] = make([]byte, width)
+			for x := range world[y] {
+				if random.Intn(2) == 0 {
+					world[y][x] = 0xff
+					cells = append(cells, Cell{X: x, Y: y})
+				}
+			}
+		}
+
+		g := BitGridFromBytes(world, width, height)
+		if len(g.Words) != height*((width+63)/64) {
+			t.Errorf("width %d: packed into %d words", width, len(g.Words))
+		}
+		if g.AliveCount() != len(cells) {
+			t.Errorf("width %d: counted %d alive cells, expected %d", width, g.AliveCount(), len(cells))
+		}
+
+		alive := g.AliveCells()
+		if len(alive) != len(cells) {
+			t.Fatalf("width %d: got %d alive cells, expected %d", width, len(alive), len(cells))
+		}
+		for i := range cells {
+			if alive[i] != cells[i] {
+				t.Fatalf("width %d: got alive cell %v, expected %v", width, alive[i], cells[i])
+			}
+		}
+
+		unpacked := BitGridFromCells(cells, width, height).Bytes()
+		for y := range world {
+			if string(unpacked[y]) != string(world[y]) {
+				t.Fatalf("width %d: row %d unpacked differently", width, y)
+			}
+		}
+	}
+}
+
+// TestXor checks the cells flipping between two worlds are exactly those alive in one but not the other.
+func TestXor(t *testing.T) {
+	a := BitGridFromCells([]Cell{{0, 0}, {64, 1}, {3, 2}}, 100, 3)
+	b := BitGridFromCells([]Cell{{0, 0}, {99, 1}, {3, 2}, {4, 2}}, 100, 3)
+	flipped := a.Xor(b).AliveCells()
+	expected := []Cell{{64, 1}, {99, 1}, {4, 2}}
+	if len(flipped) != len(expected) {
+		t.Fatalf("expected %v to flip, got %v", expected, flipped)
+	}
+	for i := range expected {
+		if flipped[i] != expected[i] {
+			t.Fatalf("expected %v to flip, got %v", expected, flipped)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package util
+
+import (
+	"errors"
+	"strings"
+)
+
+// Rule is a Life-like cellular automaton rule, such as Conway's B3/S23.
+// Bit n of Birth is set if a dead cell with n alive neighbours becomes alive,
+// bit n of Survive is set if an alive cell with n alive neighbours stays alive.
+type Rule struct {
+	Birth, Survive uint16
+}
+
+// Conway is the rule of Conway's Game of Life, B3/S23.
+var Conway = Rule{Birth: 1 << 3, Survive: 1<<2 | 1<<3}
+
+// OrDefault returns r, or Conway if r is the zero Rule, so an unset rule means the Game of Life.
+func (r Rule) OrDefault() Rule {
+	if r == (Rule{}) {
+		return Conway
+	}
+	return r
+}
+
+// Next returns whether a cell is alive in the next turn given its current state and its number of alive neighbours.
+func (r Rule) Next(alive bool, neighbours int) bool {
+	if alive {
+		return r.Survive&(1<<uint(neighbours)) != 0
+	}
+	return r.Birth&(1<<uint(neighbours)) != 0
+}
+
+// String returns the rule in B/S notation, e.g. "B36/S23".
+func (r Rule) String() string {
+	return "B" + neighbourDigits(r.Birth) + "/S" + neighbourDigits(r.Survive)
+}
+
+func neighbourDigits(mask uint16) string {
+	digits := ""
+	for n := 0; n <= 8; n++ {
+		if mask&(1<<uint(n)) != 0 {
+			digits += string(rune('0' + n))
+		}
+	}
+	return digits
+}
+
+// parseNeighbourDigits turns a list of neighbour counts such as "236" into a bitmask.
+func parseNeighbourDigits(digits string) (uint16, error) {
+	var mask uint16
+	for _, d := range digits {
+		if d < '0' || d > '8' {
+			return 0, errors.New("invalid neighbour count " + string(d) + " in rule")
+		}
+		mask |= 1 << uint(d-'0')
+	}
+	return mask, nil
+}
+
+// ParseRule parses a rulestring in B/S notation such as "B36/S23" (HighLife) or "B2/S" (Seeds).
+// The parts may come in either order, and the older S/B notation such as "23/3" is also accepted.
+func ParseRule(s string) (Rule, error) {
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(s)), "/")
+	if len(parts) != 2 {
+		return Rule{}, errors.New("rule " + s + " should have the form B3/S23")
+	}
+
+	// Without B and S prefixes the rule is in S/B notation.
+	if !strings.HasPrefix(parts[0], "B") && !strings.HasPrefix(parts[0], "S") {
+		parts[0], parts[1] = "B"+parts[1], "S"+parts[0]
+	}
+
+	var rule Rule
+	var seenBirth, seenSurvive bool
+	for _, part := range parts {
+		if part == "" {
+			return Rule{}, errors.New("rule " + s + " should have the form B3/S23")
+		}
+		mask, err := parseNeighbourDigits(part[1:])
+		if err != nil {
+			return Rule{}, err
+		}
+		switch {
+		case part[0] == 'B' && !seenBirth:
+			rule.Birth, seenBirth = mask, true
+		case part[0] == 'S' && !seenSurvive:
+			rule.Survive, seenSurvive = mask, true
+		default:
+			return Rule{}, errors.New("rule " + s + " should have exactly one B part and one S part")
+		}
+	}
+	return rule, nil
+}
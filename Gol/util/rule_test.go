@@ -0,0 +1,36 @@
+package util
+
+import "testing"
+
+// TestParseRule checks the common rulestrings parse and print back in B/S notation.
+func TestParseRule(t *testing.T) {
+	tests := map[string]string{
+		"B3/S23":       "B3/S23",
+		"b36/s23":      "B36/S23",
+		"S23/B3":       "B3/S23",
+		"23/3":         "B3/S23",
+		"B2/S":         "B2/S",
+		"B3678/S34678": "B3678/S34678",
+	}
+	for given, expected := range tests {
+		rule, err := ParseRule(given)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", given, err)
+		} else if rule.String() != expected {
+			t.Errorf("%v: parsed as %v, expected %v", given, rule, expected)
+		}
+	}
+
+	for _, invalid := range []string{"", "B3", "B9/S23", "B3/B23", "B3/S23/S1", "X3/S23"} {
+		if _, err := ParseRule(invalid); err == nil {
+			t.Errorf("%q: expected an error", invalid)
+		}
+	}
+
+	if rule, _ := ParseRule("B3/S23"); rule != Conway {
+		t.Errorf("B3/S23 should be Conway, got %v", rule)
+	}
+	if (Rule{}).OrDefault() != Conway {
+		t.Error("the zero Rule should default to Conway")
+	}
+}